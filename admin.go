@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"traefik-lazyload/pkg/auth"
+)
+
+// Routes of the status/admin surface, served on `statushost` or on `adminlisten`
+func (s *controller) adminRouter() http.Handler {
+	viewer := func(h http.HandlerFunc) http.HandlerFunc { return s.admin.Require(auth.RoleViewer, h) }
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return s.admin.Require(auth.RoleAdmin, h) }
+
+	router := http.NewServeMux()
+	router.HandleFunc("/", viewer(s.StatusHandler))
+	router.HandleFunc("/api/containers", viewer(s.ContainerListHandler))
+	router.HandleFunc("/api/providers", viewer(s.ProviderListHandler))
+	router.HandleFunc(activityAPIPrefix, viewer(s.ActivityHandler))
+	router.HandleFunc(activityAPIPrefix+"/", viewer(s.ActivityHandler))
+	router.HandleFunc(containerAPIPrefix, admin(s.ContainerActionHandler))
+	router.HandleFunc("/api/log", viewer(s.LogHandler))
+	router.HandleFunc("/metrics", viewer(s.MetricsHandler))
+
+	router.HandleFunc("/debug/pprof/", admin(pprof.Index))
+	router.HandleFunc("/debug/pprof/cmdline", admin(pprof.Cmdline))
+	router.HandleFunc("/debug/pprof/profile", admin(pprof.Profile))
+	router.HandleFunc("/debug/pprof/symbol", admin(pprof.Symbol))
+	router.HandleFunc("/debug/pprof/trace", admin(pprof.Trace))
+
+	router.Handle(httpAssetPrefix, http.StripPrefix(httpAssetPrefix, http.FileServer(http.FS(s.assets.FileSystem()))))
+	router.HandleFunc(httpAssetPrefix+"events", s.EventsHandler)
+	return router
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/service"
+)
+
+// Managed container, as served by the JSON API
+type containerInfo struct {
+	Name        string               `json:"name"`
+	ID          string               `json:"id"`
+	Phase       service.Phase        `json:"phase"`
+	PhaseSince  time.Time            `json:"phaseSince"`
+	Started     time.Time            `json:"started"`
+	LastActive  time.Time            `json:"lastActive"`
+	IdleStop    *time.Time           `json:"idleStop,omitempty"` // when it will be stopped, if still idle
+	Draining    *time.Time           `json:"draining,omitempty"` // since when its stop is waiting for connections to close
+	StopDelay   string               `json:"stopDelay"`          // effective, if adaptive
+	Adaptive    bool                 `json:"adaptive"`
+	StopMethod  string               `json:"stopMethod"`
+	Group       string               `json:"group,omitempty"`
+	Needs       []string             `json:"needs,omitempty"`
+	Pinned      bool                 `json:"pinned"`
+	Rx          int64                `json:"rx"`
+	Tx          int64                `json:"tx"`
+	Transitions []service.Transition `json:"transitions"`
+}
+
+func (s *controller) containerInfo(cts *service.ContainerState) containerInfo {
+	ret := containerInfo{
+		Name:        cts.ContainerName(),
+		ID:          cts.ID(),
+		Phase:       cts.Phase(),
+		PhaseSince:  cts.PhaseSince(),
+		Started:     cts.Started(),
+		LastActive:  cts.LastActive(),
+		StopDelay:   s.core.StopDelay(cts).String(),
+		Adaptive:    cts.AdaptiveDelay(),
+		StopMethod:  cts.StopMethod(),
+		Group:       cts.Group(),
+		Needs:       cts.Needs(),
+		Pinned:      s.core.IsPinned(cts),
+		Rx:          cts.Rx(),
+		Tx:          cts.Tx(),
+		Transitions: cts.Transitions(),
+	}
+	if !ret.Pinned && (ret.Phase == service.PhaseRunning || ret.Phase == service.PhaseIdle) {
+		stop := s.core.IdleStopAt(cts)
+		ret.IdleStop = &stop
+	}
+	if since := cts.DrainingSince(); !since.IsZero() {
+		ret.Draining = &since
+	}
+	return ret
+}
+
+// A lazyload container that isn't managed right now (ie. stopped)
+func (s *controller) stoppedContainerInfo(ct *containers.Wrapper) containerInfo {
+	pinned, _ := ct.ConfigBool("pin", false)
+	needs, _ := ct.ConfigCSV("needs", nil)
+	group, _ := ct.Config("group")
+	return containerInfo{
+		Name:   ct.Name(),
+		ID:     ct.ID,
+		Phase:  service.PhaseStopped,
+		Group:  group,
+		Needs:  needs,
+		Pinned: pinned || s.core.IsPinnedByName(ct.Name()),
+	}
+}
+
+// GET /api/containers: all lazyload containers, managed ones first
+func (s *controller) ContainerListHandler(w http.ResponseWriter, r *http.Request) {
+	active := s.core.ActiveContainers()
+	ret := make([]containerInfo, 0, len(active))
+	managed := make(map[string]bool)
+	for _, cts := range active {
+		ret = append(ret, s.containerInfo(cts))
+		managed[cts.ContainerName()] = true
+	}
+
+	qualifying, _ := s.discovery.QualifyingContainers(r.Context())
+	for i := range qualifying {
+		if !managed[qualifying[i].Name()] {
+			ret = append(ret, s.stoppedContainerInfo(&qualifying[i]))
+		}
+	}
+
+	writeJSON(w, ret)
+}
+
+// Dependency provider, as served by the JSON API
+type providerInfo struct {
+	Name     string   `json:"name"`
+	Provides []string `json:"provides"`
+	State    string   `json:"state"`
+}
+
+// GET /api/providers: containers that provide dependencies for others
+func (s *controller) ProviderListHandler(w http.ResponseWriter, r *http.Request) {
+	providers, _ := s.discovery.ProviderContainers(r.Context())
+	ret := make([]providerInfo, 0, len(providers))
+	for i := range providers {
+		provides, _ := providers[i].ConfigCSV("provides", nil)
+		ret = append(ret, providerInfo{providers[i].Name(), provides, providers[i].State})
+	}
+
+	writeJSON(w, ret)
+}
+
+const activityAPIPrefix = "/api/activity"
+
+// GET /api/activity[/<name>][?last=n]: per-poll activity samples of all containers (by
+// name), or of one
+func (s *controller) ActivityHandler(w http.ResponseWriter, r *http.Request) {
+	last, _ := strconv.Atoi(r.URL.Query().Get("last"))
+	if name := strings.Trim(strings.TrimPrefix(r.URL.Path, activityAPIPrefix), "/"); name != "" {
+		writeJSON(w, s.core.Activity(name, last))
+	} else {
+		writeJSON(w, s.core.AllActivity(last))
+	}
+}
+
+// Recent log entries, newest first
+func (s *controller) LogHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.recentLog.Entries())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
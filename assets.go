@@ -2,11 +2,20 @@ package main
 
 import (
 	"embed"
+	"io/fs"
 	"path"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
 	"traefik-lazyload/pkg/config"
 	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/logging"
 	"traefik-lazyload/pkg/service"
+	"traefik-lazyload/pkg/wake"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
 )
 
 //go:embed assets/*
@@ -16,24 +25,158 @@ const httpAssetPrefix = "/__llassets/"
 
 type SplashModel struct {
 	*service.ContainerState
-	Hostname string
+	Hostname      string
+	EstimatedWait time.Duration
+	HasEstimate   bool // false if never started before
+	QueuePosition int  // 0 if not waiting for room under the running limits
 }
 
 type StatusPageModel struct {
 	Active         []*service.ContainerState
 	Qualifying     []containers.Wrapper
 	Providers      []containers.Wrapper
+	Checkpoints    []service.CheckpointInfo
+	Memory         service.MemoryStatus
+	WakeBlocked    []wake.RuleCount
+	StartStats     map[string]service.StartStats      // container name -> stats
+	Activity       map[string]service.ActivitySummary // container name -> summary
+	AdaptiveDelays []service.AdaptiveDelay
+	Predictions    []service.Prediction
+	RecentLog      []logging.Entry // newest first
+	Leader         bool
+	Admin          bool // viewer can use the container actions
 	RuntimeMetrics string
 }
 
 type assetTemplates struct {
-	splash *template.Template
-	status *template.Template
+	fs *overlayFS
+
+	mux       sync.RWMutex
+	templates map[string]*template.Template // filename -> template
+	missing   map[string]bool               // splash templates warned about since the last load
 }
 
 func LoadTemplates() *assetTemplates {
-	return &assetTemplates{
-		splash: template.Must(template.ParseFS(httpAssets, path.Join("assets", config.Model.Splash))),
-		status: template.Must(template.ParseFS(httpAssets, "assets/status.html")),
+	subFs, _ := fs.Sub(httpAssets, "assets")
+	ret := &assetTemplates{
+		fs: newOverlayFS(config.Model.SplashDir, subFs),
+	}
+	if err := ret.Reload(); err != nil {
+		logrus.Fatal(err)
+	}
+	if ret.Splash("") == nil {
+		logrus.Fatalf("Splash template %s not found", config.Model.Splash)
+	}
+	return ret
+}
+
+// Re-parse all templates from disk and embedded assets. On failure, the previous
+// templates are kept
+func (s *assetTemplates) Reload() error {
+	names, err := s.fs.Glob("*.html")
+	if err != nil {
+		return err
 	}
+
+	templates := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		tmpl, err := template.New(name).Funcs(templateFuncs).ParseFS(s.fs, name)
+		if err != nil {
+			return err
+		}
+		templates[name] = tmpl
+	}
+
+	s.mux.Lock()
+	s.templates = templates
+	s.missing = make(map[string]bool)
+	s.mux.Unlock()
+	return nil
+}
+
+// Returns the named splash template, or the configured default if name is empty or not
+// found. A missing template is warned about once per load
+func (s *assetTemplates) Splash(name string) *template.Template {
+	s.mux.RLock()
+	if name != "" {
+		if path.Ext(name) == "" {
+			name += ".html"
+		}
+		if tmpl, ok := s.templates[name]; ok {
+			s.mux.RUnlock()
+			return tmpl
+		}
+	}
+	tmpl, warned := s.templates[config.Model.Splash], s.missing[name]
+	s.mux.RUnlock()
+
+	if name != "" && !warned {
+		s.mux.Lock()
+		if !s.missing[name] {
+			s.missing[name] = true
+			logrus.Warnf("Splash template %s not found, using default", name)
+		}
+		s.mux.Unlock()
+	}
+	return tmpl
+}
+
+// Page asking the user to confirm starting a container (wake.auth=click)
+func (s *assetTemplates) Wake() *template.Template {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.templates["wake.html"]
+}
+
+func (s *assetTemplates) Status() *template.Template {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.templates["status.html"]
+}
+
+// Serves static assets, preferring the splash directory. Templates aren't served
+func (s *assetTemplates) FileSystem() fs.FS {
+	return staticFS{s.fs}
+}
+
+// Watch a directory for changes, and reload templates when they occur
+func (s *assetTemplates) Watch(dir string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		const debounce = 250 * time.Millisecond
+		var reload <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if strings.HasSuffix(event.Name, ".html") {
+					reload = time.After(debounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logrus.Warnf("Error watching %s: %v", dir, err)
+			case <-reload:
+				reload = nil
+				if err := s.Reload(); err != nil {
+					logrus.Errorf("Unable to reload templates: %v", err)
+				} else {
+					logrus.Info("Reloaded templates")
+				}
+			}
+		}
+	}()
+
+	return watcher, nil
 }
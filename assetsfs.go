@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Filesystem that serves from an optional on-disk directory first, falling back
+// to the embedded assets
+type overlayFS struct {
+	dir  fs.FS // may be nil
+	base fs.FS
+}
+
+func newOverlayFS(dir string, base fs.FS) *overlayFS {
+	ret := &overlayFS{base: base}
+	if dir != "" {
+		ret.dir = os.DirFS(dir)
+	}
+	return ret
+}
+
+func (s *overlayFS) Open(name string) (fs.File, error) {
+	if s.dir != nil {
+		if f, err := s.dir.Open(name); err == nil {
+			return f, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return s.base.Open(name)
+}
+
+// Returns the union of the matching files in both filesystems
+func (s *overlayFS) Glob(pattern string) ([]string, error) {
+	matches, err := fs.Glob(s.base, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.dir != nil {
+		dirMatches, err := fs.Glob(s.dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool, len(matches))
+		for _, m := range matches {
+			seen[m] = true
+		}
+		for _, m := range dirMatches {
+			if !seen[m] {
+				matches = append(matches, m)
+			}
+		}
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Filesystem of the static assets, without the templates (which are only served rendered)
+type staticFS struct {
+	fs.FS
+}
+
+func (s staticFS) Open(name string) (fs.File, error) {
+	if strings.EqualFold(path.Ext(name), ".html") {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return s.FS.Open(name)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverlayFS(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "splash.html"), []byte("custom"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "other.html"), []byte("other"), 0o600))
+	base := fstest.MapFS{
+		"splash.html": {Data: []byte("default")},
+		"splash.css":  {Data: []byte("css")},
+	}
+	overlay := newOverlayFS(dir, base)
+
+	data, err := fs.ReadFile(overlay, "splash.html")
+	assert.NoError(t, err)
+	assert.Equal(t, "custom", string(data))
+
+	names, err := overlay.Glob("*.html")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other.html", "splash.html"}, names)
+
+	// Templates aren't served as static files
+	static := staticFS{overlay}
+	_, err = static.Open("splash.html")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = static.Open("OTHER.HTML")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	data, err = fs.ReadFile(static, "splash.css")
+	assert.NoError(t, err)
+	assert.Equal(t, "css", string(data))
+}
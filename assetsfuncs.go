@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Helper functions available to all templates
+var templateFuncs = template.FuncMap{
+	"duration": humanDuration,
+	"since":    func(t time.Time) string { return humanDuration(time.Since(t)) },
+	"bytes":    humanBytes,
+	"env":      os.Getenv,
+	"redact":   redactLabel,
+}
+
+// Label names that hold secrets, and shouldn't be shown
+var secretLabelSuffixes = []string{"token", "users", "password", "secret"}
+
+// Hide the value of a secret label
+func redactLabel(name, value string) string {
+	lower := strings.ToLower(name)
+	for _, suffix := range secretLabelSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return "[redacted]"
+		}
+	}
+	return value
+}
+
+func humanDuration(d time.Duration) string {
+	if d >= time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+func humanBytes(v interface{}) string {
+	var n float64
+	switch val := v.(type) {
+	case int:
+		n = float64(val)
+	case int64:
+		n = float64(val)
+	case uint64:
+		n = float64(val)
+	case float64:
+		n = val
+	default:
+		return fmt.Sprint(v)
+	}
+
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit || n <= -unit*unit {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGTPE"[exp])
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHumanBytes(t *testing.T) {
+	assert.Equal(t, "512 B", humanBytes(512))
+	assert.Equal(t, "1.5 KiB", humanBytes(int64(1536)))
+	assert.Equal(t, "2.0 MiB", humanBytes(uint64(2*1024*1024)))
+	assert.Equal(t, "abc", humanBytes("abc"))
+}
+
+func TestRedactLabel(t *testing.T) {
+	assert.Equal(t, "[redacted]", redactLabel("lazyloader.wake.token", "abc"))
+	assert.Equal(t, "[redacted]", redactLabel("lazyloader.wake.users", "a:b"))
+	assert.Equal(t, "5m", redactLabel("lazyloader.stopdelay", "5m"))
+}
+
+func TestHumanDuration(t *testing.T) {
+	assert.Equal(t, "1.2s", humanDuration(1234*time.Millisecond))
+	assert.Equal(t, "2m3s", humanDuration(2*time.Minute+3400*time.Millisecond))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"traefik-lazyload/pkg/auth"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/logging"
+	"traefik-lazyload/pkg/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+const containerAPIPrefix = "/api/containers/"
+
+type actionResponse struct {
+	Container string `json:"container"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Admin actions on a container: POST /api/containers/<name>/<start|stop|pin|unpin>
+//
+// Browsers send basic auth and SSO cookies on their own, so unless authenticated with
+// a bearer token, the X-Requested-With header is required (which a cross-site form
+// can't set)
+func (s *controller) ContainerActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := auth.FromContext(r.Context())
+	if id.Method != "bearer" && r.Header.Get("X-Requested-With") == "" {
+		http.Error(w, "Missing X-Requested-With header", http.StatusForbidden)
+		return
+	}
+
+	name, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, containerAPIPrefix), "/")
+	if !ok || name == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Model.Timeout)
+	defer cancel()
+
+	var err error
+	switch action {
+	case "start":
+		_, err = s.core.StartByName(ctx, name)
+	case "stop":
+		err = s.core.StopByName(ctx, name)
+	case "pin":
+		s.core.SetPinned(name, true)
+	case "unpin":
+		s.core.SetPinned(name, false)
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	log := containers.Logger("", name).WithFields(logrus.Fields{
+		logging.FieldUser:   id.User,
+		logging.FieldAction: action,
+	})
+	if err != nil {
+		log.Warnf("Requested %s failed: %v", action, err)
+	} else {
+		log.Infof("Requested %s", action)
+	}
+
+	resp := actionResponse{Container: name, Action: action}
+	code := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		switch {
+		case errors.Is(err, containers.ErrNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, service.ErrNotRunning):
+			code = http.StatusConflict
+		case errors.Is(err, service.ErrRateLimited):
+			code = http.StatusTooManyRequests
+		case errors.Is(err, service.ErrOverBudget):
+			code = http.StatusInsufficientStorage
+		default:
+			code = http.StatusInternalServerError
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const unixPrefix = "unix:"
+
+// Listen on a tcp address, or on a unix socket as `unix:/path/to.sock`
+func listen(addr string) (net.Listener, error) {
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if path == addr {
+		return net.Listen("tcp", addr)
+	}
+
+	// Remove a stale socket from a previous run, but nothing else that may be there
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := listen("unix:" + sock)
+	if !assert.NoError(t, err) {
+		return
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", sock) },
+	}}
+	resp, err := client.Get("http://admin/")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	}
+
+	// Re-listening replaces a stale socket
+	listener.Close()
+	listener, err = listen("unix:" + sock)
+	assert.NoError(t, err)
+	listener.Close()
+}
+
+func TestListenUnixKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("keep"), 0o600))
+
+	_, err := listen("unix:" + path)
+	assert.Error(t, err)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "keep", string(data))
+}
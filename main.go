@@ -2,26 +2,38 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"time"
+	"traefik-lazyload/pkg/auth"
 	"traefik-lazyload/pkg/config"
 	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/coordination"
+	"traefik-lazyload/pkg/logging"
+	"traefik-lazyload/pkg/notify"
 	"traefik-lazyload/pkg/service"
+	"traefik-lazyload/pkg/sni"
+	"traefik-lazyload/pkg/wake"
 
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 )
 
 type controller struct {
-	assets    assetTemplates
+	assets    *assetTemplates
 	core      *service.Core
 	discovery *containers.Discovery
+	wake      *wake.Filter
+	csrf      *auth.CSRF
+	admin     *auth.Authenticator
+	recentLog *logging.Recent
+	adminMux  http.Handler // status page, API, metrics and pprof
 }
 
 func mustCreateDockerClient() *client.Client {
@@ -36,6 +48,17 @@ func mustCreateDockerClient() *client.Client {
 func main() {
 	config.Load()
 
+	if err := logging.SetFormat(config.Model.LogFormat); err != nil {
+		logrus.Fatal(err)
+	}
+	switch config.Model.TCPMode {
+	case "", sni.ModeProxy, sni.ModeClose:
+	default:
+		logrus.Fatalf("Unknown tcpmode %q, expected %s or %s", config.Model.TCPMode, sni.ModeProxy, sni.ModeClose)
+	}
+	recentLog := logging.NewRecent(config.Model.LogHistory)
+	logrus.AddHook(recentLog)
+
 	if config.Model.Verbose {
 		logrus.SetLevel(logrus.DebugLevel)
 		logrus.Debug("Verbose is on")
@@ -44,52 +67,151 @@ func main() {
 	dockerClient := mustCreateDockerClient()
 	discovery := containers.NewDiscovery(dockerClient)
 
+	var elector coordination.Elector
+	switch config.Model.Coordination {
+	case "":
+	case "file":
+		elector = coordination.NewFileLease(config.Model.LeaseFile, coordination.InstanceID(), config.Model.LeaseTTL)
+	case "docker":
+		elector = coordination.NewDockerElector(dockerClient, config.SubLabel("instance"))
+	default:
+		logrus.Fatalf("Unknown coordination mode: %s", config.Model.Coordination)
+	}
+
 	var err error
-	core, err := service.New(dockerClient, discovery, config.Model.PollFreq)
+	core, err := service.New(dockerClient, discovery, elector, config.Model.PollFreq)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	defer core.Close()
 
-	if config.Model.StopAtBoot {
+	if len(config.Model.Webhooks) > 0 {
+		hooks := make([]notify.Hook, len(config.Model.Webhooks))
+		for i, hook := range config.Model.Webhooks {
+			hooks[i] = notify.Hook(hook)
+		}
+		notifier, err := notify.New(hooks)
+		if err != nil {
+			logrus.Fatalf("Invalid webhooks: %v", err)
+		}
+		defer notifier.Close()
+
+		_, events, unsubscribe := core.Subscribe("")
+		defer unsubscribe()
+		go notifier.Forward(events)
+	}
+
+	if config.Model.StopAtBoot && core.IsLeader() {
 		core.StopAll()
 	}
 
+	wakeFilter, err := wake.New(wake.Rules{
+		UserAgents: config.Model.Wake.DenyUserAgents,
+		AllowIPs:   config.Model.Wake.AllowIPs,
+		DenyIPs:    config.Model.Wake.DenyIPs,
+		Paths:      config.Model.Wake.DenyPaths,
+	}, config.Model.Wake.TrustForwarded)
+	if err != nil {
+		logrus.Fatalf("Invalid wake rules: %v", err)
+	}
+
+	statusAuth, err := auth.New(auth.Config(config.Model.StatusAuth))
+	if err != nil {
+		logrus.Fatalf("Invalid status auth: %v", err)
+	}
+
 	controller := controller{
-		*LoadTemplates(),
+		LoadTemplates(),
 		core,
 		discovery,
+		wakeFilter,
+		auth.NewCSRF(time.Hour),
+		statusAuth,
+		recentLog,
+		nil,
+	}
+
+	controller.adminMux = controller.adminRouter()
+
+	if config.Model.SplashDir != "" {
+		watcher, err := controller.assets.Watch(config.Model.SplashDir)
+		if err != nil {
+			logrus.Warnf("Unable to watch %s for changes: %v", config.Model.SplashDir, err)
+		} else {
+			defer watcher.Close()
+		}
 	}
 
 	// Set up http server
-	subFs, _ := fs.Sub(httpAssets, "assets")
 	router := http.NewServeMux()
-	router.Handle(httpAssetPrefix, http.StripPrefix(httpAssetPrefix, http.FileServer(http.FS(subFs))))
+	router.Handle(httpAssetPrefix, http.StripPrefix(httpAssetPrefix, http.FileServer(http.FS(controller.assets.FileSystem()))))
+	router.HandleFunc(httpAssetPrefix+"events", controller.PublicEventsHandler)
 	router.HandleFunc("/", controller.ContainerHandler)
 
 	srv := &http.Server{
-		Addr:    config.Model.Listen,
 		Handler: router,
 	}
 
+	var adminSrv *http.Server
+	if config.Model.AdminListen != "" {
+		if config.Model.StatusHost != "" {
+			logrus.Warnf("adminlisten is set, so the status page isn't served on statushost %s", config.Model.StatusHost)
+		}
+		listener, err := listen(config.Model.AdminListen)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		adminSrv = &http.Server{Handler: controller.adminMux}
+		go func() {
+			logrus.Infof("Admin listening on %s...", config.Model.AdminListen)
+			if err := adminSrv.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logrus.Fatal(err)
+			}
+		}()
+	}
+
+	var sniServer *sni.Server
+	if config.Model.TCPListen != "" {
+		sniServer = sni.New(core, config.Model.TCPMode, config.Model.Timeout)
+		go func() {
+			logrus.Infof("Listening for TLS on %s (%s)...", config.Model.TCPListen, config.Model.TCPMode)
+			if err := sniServer.ListenAndServe(config.Model.TCPListen); err != nil {
+				logrus.Fatal(err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 
 	go func() {
 		<-sigChan
 		logrus.Info("Shutting down...")
+		if sniServer != nil {
+			sniServer.Close()
+		}
+		if adminSrv != nil {
+			adminSrv.Shutdown(context.Background())
+		}
 		srv.Shutdown(context.Background())
 	}()
 
+	listener, err := listen(config.Model.Listen)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 	logrus.Infof("Listening on %s...", config.Model.Listen)
-	if config.Model.StatusHost != "" {
+	if config.Model.StatusHost != "" && config.Model.AdminListen == "" {
 		logrus.Infof("Status host set to %s", config.Model.StatusHost)
 	}
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
 		logrus.Fatal(err)
 	}
 }
 
+// Longest X-Request-Id taken from a request; longer ones are replaced
+const maxRequestIDLen = 64
+
 func (s *controller) ContainerHandler(w http.ResponseWriter, r *http.Request) {
 	host := r.Host
 	if host == "" {
@@ -97,48 +219,156 @@ func (s *controller) ContainerHandler(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "Not Found")
 		return
 	}
-	if host == config.Model.StatusHost && config.Model.StatusHost != "" {
-		s.StatusHandler(w, r)
+	if host == config.Model.StatusHost && config.Model.StatusHost != "" && config.Model.AdminListen == "" {
+		s.adminMux.ServeHTTP(w, r)
+		return
+	}
+
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		requestID = logging.NewRequestID()
+	}
+	w.Header().Set("X-Request-Id", requestID)
+	ctx := logging.WithRequestID(r.Context(), requestID)
+	log := logrus.WithFields(logrus.Fields{logging.FieldHost: host, logging.FieldRequestID: requestID})
+
+	ct, err := s.core.FindHost(r.Context(), host)
+	if err != nil && !errors.Is(err, containers.ErrNotFound) {
+		log.Warnf("Unable to find container: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "unable to find container")
+		return
+	}
+	if decision := s.checkWake(r, ct); decision.Blocked() {
+		log.Debugf("Not waking for %s %s: blocked by %s", r.RemoteAddr, r.URL.Path, decision.Rule)
+		decision.WriteResponse(w, r)
+		return
+	}
+	authorized, redirect := s.authorizeWake(w, r, host, ct)
+	if !authorized {
 		return
 	}
 
-	if sOpts, err := s.core.StartHost(host); err != nil {
+	if sOpts, err := s.core.StartFound(ctx, host, ct); err != nil {
 		if errors.Is(err, containers.ErrNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			io.WriteString(w, "not found")
+		} else if errors.Is(err, service.ErrRateLimited) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, err.Error())
+		} else if errors.Is(err, service.ErrOverBudget) {
+			w.WriteHeader(http.StatusInsufficientStorage)
+			io.WriteString(w, err.Error())
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, err.Error())
 		}
+	} else if redirect {
+		http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
 	} else {
-		w.WriteHeader(http.StatusAccepted)
-		renderErr := s.assets.splash.Execute(w, SplashModel{
-			Hostname:       host,
-			ContainerState: sOpts,
-		})
-		if renderErr != nil {
-			logrus.Error(renderErr)
-		}
+		s.writeStarting(w, r, host, sOpts)
+	}
+}
+
+// Check a request against the wake rules, with the overrides of the container it is for (if found)
+func (s *controller) checkWake(r *http.Request, ct *containers.Wrapper) wake.Decision {
+	var ov *wake.Override
+	if ct != nil {
+		ov = wake.OverrideFromContainer(ct)
 	}
+	return s.wake.Check(r, ov)
 }
 
 func (s *controller) StatusHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	case "/":
-		var stats runtime.MemStats
-		runtime.ReadMemStats(&stats)
-
-		qualifying, _ := s.discovery.QualifyingContainers(r.Context())
-		providers, _ := s.discovery.ProviderContainers(r.Context())
-
-		s.assets.status.Execute(w, StatusPageModel{
-			Active:         s.core.ActiveContainers(),
-			Qualifying:     qualifying,
-			Providers:      providers,
-			RuntimeMetrics: fmt.Sprintf("Heap=%d, InUse=%d, Total=%d, Sys=%d, NumGC=%d", stats.HeapAlloc, stats.HeapInuse, stats.TotalAlloc, stats.Sys, stats.NumGC),
-		})
-	default:
+	if r.URL.Path != "/" {
 		w.WriteHeader(http.StatusNotFound)
 		io.WriteString(w, "Status page not found")
+		return
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	qualifying, _ := s.discovery.QualifyingContainers(r.Context())
+	providers, _ := s.discovery.ProviderContainers(r.Context())
+	id, _ := auth.FromContext(r.Context())
+
+	s.assets.Status().Execute(w, StatusPageModel{
+		Active:         s.core.ActiveContainers(),
+		Qualifying:     qualifying,
+		Providers:      providers,
+		Checkpoints:    s.core.Checkpoints(r.Context()),
+		Memory:         s.core.MemoryStatus(),
+		WakeBlocked:    s.wake.Blocked(),
+		StartStats:     s.core.AllStartStats(),
+		Activity:       s.core.ActivitySummaries(),
+		AdaptiveDelays: s.core.AdaptiveDelays(),
+		Predictions:    s.core.Predictions(r.Context()),
+		RecentLog:      s.recentLog.Entries(),
+		Leader:         s.core.IsLeader(),
+		Admin:          id.Role >= auth.RoleAdmin,
+		RuntimeMetrics: fmt.Sprintf("Heap=%d, InUse=%d, Total=%d, Sys=%d, NumGC=%d", stats.HeapAlloc, stats.HeapInuse, stats.TotalAlloc, stats.Sys, stats.NumGC),
+	})
+}
+
+// Events on the public listener: only those of a single container (for its splash page).
+// The stream of every container is served by the admin router, incl. on statushost
+func (s *controller) PublicEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Host == config.Model.StatusHost && config.Model.StatusHost != "" && config.Model.AdminListen == "" {
+		s.adminMux.ServeHTTP(w, r)
+		return
 	}
+	if r.URL.Query().Get("name") == "" {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Not Found")
+		return
+	}
+	s.EventsHandler(w, r)
+}
+
+// Server-sent event stream of container lifecycle events. Filtered to a single
+// container with `?name=<container-name>`
+func (s *controller) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		io.WriteString(w, "streaming unsupported")
+		return
+	}
+
+	// Events of every container are for the status page
+	name := r.URL.Query().Get("name")
+	if name == "" && s.admin.Authenticate(r).Role < auth.RoleViewer {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Unauthorized")
+		return
+	}
+
+	history, events, unsubscribe := s.core.Subscribe(name)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, ev := range history {
+		writeEvent(w, ev)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			writeEvent(w, ev)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w io.Writer, ev service.Event) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
 }
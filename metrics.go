@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"traefik-lazyload/pkg/service"
+)
+
+// Prometheus text exposition, written by hand to avoid the client library
+func (s *controller) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	active := s.core.ActiveContainers()
+
+	byPhase := make(map[service.Phase]int)
+	for _, cts := range active {
+		byPhase[cts.Phase()]++
+	}
+	writeMetricHeader(out, "lazyloader_containers", "gauge", "Managed containers, by phase")
+	for phase := service.PhaseStopped; phase <= service.PhaseFailed; phase++ {
+		writeMetric(out, "lazyloader_containers", byPhase[phase], "phase", phase.String())
+	}
+
+	writeMetricHeader(out, "lazyloader_container_rx_bytes", "gauge", "Bytes received by a container, as of the last poll")
+	for _, cts := range active {
+		writeMetric(out, "lazyloader_container_rx_bytes", cts.Rx(), "container", cts.ContainerName())
+	}
+	writeMetricHeader(out, "lazyloader_container_tx_bytes", "gauge", "Bytes sent by a container, as of the last poll")
+	for _, cts := range active {
+		writeMetric(out, "lazyloader_container_tx_bytes", cts.Tx(), "container", cts.ContainerName())
+	}
+	writeMetricHeader(out, "lazyloader_container_last_active_timestamp_seconds", "gauge", "When a container last had network activity")
+	for _, cts := range active {
+		writeMetric(out, "lazyloader_container_last_active_timestamp_seconds", cts.LastActive().Unix(), "container", cts.ContainerName())
+	}
+
+	writeMetricHeader(out, "lazyloader_cold_start_seconds", "summary", "Time from request until ready, over recent starts")
+	for name, stats := range s.core.AllStartStats() {
+		writeMetric(out, "lazyloader_cold_start_seconds", stats.ReadyP50.Seconds(), "container", name, "quantile", "0.5")
+		writeMetric(out, "lazyloader_cold_start_seconds", stats.ReadyP95.Seconds(), "container", name, "quantile", "0.95")
+		writeMetric(out, "lazyloader_cold_start_seconds_count", stats.Count, "container", name)
+	}
+
+	writeMetricHeader(out, "lazyloader_wake_blocked_total", "counter", "Requests that didn't wake a container, by rule")
+	for _, rule := range s.wake.Blocked() {
+		writeMetric(out, "lazyloader_wake_blocked_total", rule.Count, "rule", rule.Rule)
+	}
+
+	if memory := s.core.MemoryStatus(); memory.Budget > 0 {
+		writeMetricHeader(out, "lazyloader_memory_budget_bytes", "gauge", "Memory budget for running containers")
+		writeMetric(out, "lazyloader_memory_budget_bytes", memory.Budget)
+		writeMetricHeader(out, "lazyloader_memory_used_bytes", "gauge", "Estimated memory of running containers")
+		writeMetric(out, "lazyloader_memory_used_bytes", memory.Used)
+	}
+
+	leader := 0
+	if s.core.IsLeader() {
+		leader = 1
+	}
+	writeMetricHeader(out, "lazyloader_leader", "gauge", "1 if this instance stops idle containers")
+	writeMetric(out, "lazyloader_leader", leader)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeMetricHeader(out, "go_goroutines", "gauge", "Number of goroutines")
+	writeMetric(out, "go_goroutines", runtime.NumGoroutine())
+	writeMetricHeader(out, "go_memstats_heap_alloc_bytes", "gauge", "Heap bytes allocated and in use")
+	writeMetric(out, "go_memstats_heap_alloc_bytes", mem.HeapAlloc)
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Write a sample, with labels as name/value pairs
+func writeMetric(w io.Writer, name string, value interface{}, labels ...string) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, labels[i], metricLabelEscaper.Replace(labels[i+1]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %v\n", value)
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
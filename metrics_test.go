@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetric(t *testing.T) {
+	var sb strings.Builder
+	writeMetricHeader(&sb, "test_total", "counter", "A test")
+	writeMetric(&sb, "test_total", 3)
+	writeMetric(&sb, "test_total", 1.5, "rule", `useragent:"bot\b"`, "quantile", "0.5")
+
+	assert.Equal(t, `# HELP test_total A test
+# TYPE test_total counter
+test_total 3
+test_total{rule="useragent:\"bot\\b\"",quantile="0.5"} 1.5
+`, sb.String())
+}
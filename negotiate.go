@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"traefik-lazyload/pkg/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	responseHTML = "html"
+	responseJSON = "json"
+	responseText = "text"
+)
+
+type startingResponse struct {
+	Status     string `json:"status"`
+	Container  string `json:"container"`
+	Host       string `json:"host"`
+	RetryAfter int    `json:"retryAfter"` // seconds
+	Queue      int    `json:"queuePosition,omitempty"`
+}
+
+// Pick a response style from an Accept header, honoring q-values
+// Anything that doesn't ask for html or json gets plain text
+func negotiateResponse(accept string) string {
+	style, bestQ := responseText, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && k == "q" {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		var candidate string
+		switch mediaType {
+		case "text/html", "application/xhtml+xml":
+			candidate = responseHTML
+		case "application/json":
+			candidate = responseJSON
+		default:
+			continue
+		}
+		if q > bestQ {
+			style, bestQ = candidate, q
+		}
+	}
+	return style
+}
+
+// Respond to a request for a container that is starting, in the style the client
+// wants (or the container forces)
+func (s *controller) writeStarting(w http.ResponseWriter, r *http.Request, host string, cts *service.ContainerState) {
+	style := cts.ResponseStyle()
+	if style == "" {
+		style = negotiateResponse(r.Header.Get("Accept"))
+	}
+
+	retryAfter := int(math.Ceil(cts.RetryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Cache-Control", "no-store")
+
+	queuePos := s.core.QueuePosition(cts)
+
+	code := cts.ResponseCode()
+	switch style {
+	case responseHTML:
+		if code == 0 {
+			code = http.StatusAccepted
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(code)
+		estimate, hasEstimate := s.core.EstimatedWait(cts)
+		renderErr := s.assets.Splash(cts.Splash()).Execute(w, SplashModel{
+			Hostname:       host,
+			ContainerState: cts,
+			EstimatedWait:  estimate,
+			HasEstimate:    hasEstimate,
+			QueuePosition:  queuePos,
+		})
+		if renderErr != nil {
+			logrus.Error(renderErr)
+		}
+	case responseJSON:
+		if code == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(startingResponse{
+			Status:     cts.Phase().String(),
+			Container:  cts.Name(),
+			Host:       host,
+			RetryAfter: retryAfter,
+			Queue:      queuePos,
+		})
+	default:
+		if code == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		if queuePos > 0 {
+			io.WriteString(w, "Queued "+host+" (position "+strconv.Itoa(queuePos)+"), retry in "+strconv.Itoa(retryAfter)+"s\n")
+		} else {
+			io.WriteString(w, "Starting "+host+", retry in "+strconv.Itoa(retryAfter)+"s\n")
+		}
+	}
+}
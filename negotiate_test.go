@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateResponse(t *testing.T) {
+	assert.Equal(t, responseHTML, negotiateResponse("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"))
+	assert.Equal(t, responseJSON, negotiateResponse("application/json"))
+	assert.Equal(t, responseJSON, negotiateResponse("text/html;q=0.5, application/json"))
+	assert.Equal(t, responseText, negotiateResponse("*/*"))
+	assert.Equal(t, responseText, negotiateResponse(""))
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHtpasswd(t *testing.T) {
+	users, err := ParseHtpasswd(strings.NewReader(`
+# comment
+alice:{SHA}qvTGHdzF6KLavt4PO0gs2a6pQ00=
+bob:secret
+erin:$apr1$qHDFfhPC$nITSVHgYbDAK1Y0acGRnY0
+frank:$1$saltsalt$fFhQNRZ9A4AYLdo7vOe8a0
+`))
+	assert.NoError(t, err)
+	assert.True(t, users.Verify("alice", "hello"))
+	assert.False(t, users.Verify("alice", "nope"))
+	assert.True(t, users.Verify("bob", "secret"))
+	assert.False(t, users.Verify("carol", "secret"))
+	assert.True(t, users.Verify("erin", "myPassword"))
+	assert.False(t, users.Verify("erin", "mypassword"))
+	assert.True(t, users.Verify("frank", "hello"))
+
+	_, err = ParseHtpasswdList("dave:$2y$05$abc")
+	assert.Error(t, err)
+
+	users, err = ParseHtpasswdList("a:1, b:{SHA}qvTGHdzF6KLavt4PO0gs2a6pQ00=")
+	assert.NoError(t, err)
+	assert.True(t, users.Verify("b", "hello"))
+}
+
+func TestCSRF(t *testing.T) {
+	csrf := NewCSRF(time.Minute)
+
+	w := httptest.NewRecorder()
+	token := csrf.Issue(w, httptest.NewRequest(http.MethodGet, "/", nil), "a.example.com")
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+
+	post := httptest.NewRequest(http.MethodPost, "/", nil)
+	post.AddCookie(cookies[0])
+	assert.True(t, csrf.Verify(post, "a.example.com", token))
+	assert.False(t, csrf.Verify(post, "b.example.com", token))
+	assert.False(t, csrf.Verify(post, "a.example.com", token+"x"))
+
+	// Without the cookie (eg. cross-site), the token is useless
+	assert.False(t, csrf.Verify(httptest.NewRequest(http.MethodPost, "/", nil), "a.example.com", token))
+
+	expired := NewCSRF(-time.Minute)
+	token = expired.Issue(httptest.NewRecorder(), post, "a.example.com")
+	assert.False(t, expired.Verify(post, "a.example.com", token))
+}
+
+func TestAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+	htpasswd := dir + "/htpasswd"
+	assert.NoError(t, os.WriteFile(htpasswd, []byte("alice:{SHA}qvTGHdzF6KLavt4PO0gs2a6pQ00=\nbob:pass\n"), 0600))
+
+	authn, err := New(Config{
+		Tokens:        []string{"viewtoken", "admin:admintoken"},
+		Htpasswd:      htpasswd,
+		Admins:        []string{"alice", "sso-admin"},
+		ForwardHeader: "X-Forwarded-User",
+		ForwardIPs:    []string{"10.0.0.0/8"},
+	})
+	assert.NoError(t, err)
+
+	req := func(setup func(r *http.Request)) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.168.0.1:1234"
+		setup(r)
+		return r
+	}
+
+	assert.Equal(t, RoleNone, authn.Authenticate(req(func(r *http.Request) {})).Role)
+	assert.Equal(t, RoleViewer, authn.Authenticate(req(func(r *http.Request) { r.Header.Set("Authorization", "Bearer viewtoken") })).Role)
+	assert.Equal(t, RoleAdmin, authn.Authenticate(req(func(r *http.Request) { r.Header.Set("Authorization", "Bearer admintoken") })).Role)
+	assert.Equal(t, RoleNone, authn.Authenticate(req(func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") })).Role)
+	assert.Equal(t, RoleAdmin, authn.Authenticate(req(func(r *http.Request) { r.SetBasicAuth("alice", "hello") })).Role)
+	assert.Equal(t, RoleViewer, authn.Authenticate(req(func(r *http.Request) { r.SetBasicAuth("bob", "pass") })).Role)
+	assert.Equal(t, RoleNone, authn.Authenticate(req(func(r *http.Request) { r.SetBasicAuth("bob", "wrong") })).Role)
+
+	// Forward header only trusted from the proxy
+	assert.Equal(t, RoleNone, authn.Authenticate(req(func(r *http.Request) { r.Header.Set("X-Forwarded-User", "sso-admin") })).Role)
+	id := authn.Authenticate(req(func(r *http.Request) {
+		r.RemoteAddr = "10.1.1.1:1234"
+		r.Header.Set("X-Forwarded-User", "sso-admin")
+	}))
+	assert.Equal(t, Identity{"sso-admin", RoleAdmin, "forward"}, id)
+
+	_, err = New(Config{ForwardHeader: "X-Forwarded-User"})
+	assert.Error(t, err)
+}
+
+func TestAuthenticatorRequire(t *testing.T) {
+	open, err := New(Config{})
+	assert.NoError(t, err)
+
+	handler := open.Require(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		id, ok := FromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "anonymous", id.Method)
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Nothing configured: anyone can view, nobody is admin
+	w = httptest.NewRecorder()
+	open.Require(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
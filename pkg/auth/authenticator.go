@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// What an authenticated user may do on the status/admin surface
+type Role int
+
+const (
+	RoleNone   Role = iota
+	RoleViewer      // see the status page
+	RoleAdmin       // also start, stop and pin containers
+)
+
+var roleNames = [...]string{
+	RoleNone:   "none",
+	RoleViewer: "viewer",
+	RoleAdmin:  "admin",
+}
+
+func (s Role) String() string {
+	if s < 0 || int(s) >= len(roleNames) {
+		return fmt.Sprintf("role(%d)", int(s))
+	}
+	return roleNames[s]
+}
+
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return Role(role), nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q", name)
+}
+
+type Config struct {
+	Tokens        []string // bearer tokens, as `token` (viewer) or `role:token`
+	Htpasswd      string   // htpasswd file for basic auth
+	Admins        []string // basic auth and forwarded users with the admin role; others are viewers
+	ForwardHeader string   // header with the user, set by an upstream forward-auth (eg. X-Forwarded-User)
+	ForwardIPs    []string // CIDRs the forward header is trusted from (required with ForwardHeader)
+	Anonymous     string   // role of unauthenticated requests; empty is viewer if nothing else is configured, otherwise none
+}
+
+// Who made a request
+type Identity struct {
+	User   string
+	Role   Role
+	Method string // bearer, basic, forward or anonymous
+}
+
+// Authenticates requests to the status/admin surface
+type Authenticator struct {
+	tokens        map[string]Role
+	users         Htpasswd
+	admins        map[string]bool
+	forwardHeader string
+	forwardIPs    []*net.IPNet
+	anonymous     Role
+}
+
+func New(cfg Config) (*Authenticator, error) {
+	ret := &Authenticator{
+		tokens:        make(map[string]Role),
+		admins:        make(map[string]bool),
+		forwardHeader: cfg.ForwardHeader,
+	}
+
+	for _, entry := range cfg.Tokens {
+		role, token := RoleViewer, entry
+		if name, secret, ok := strings.Cut(entry, ":"); ok {
+			parsed, err := ParseRole(name)
+			if err != nil {
+				return nil, err
+			}
+			role, token = parsed, secret
+		}
+		ret.tokens[token] = role
+	}
+
+	if cfg.Htpasswd != "" {
+		users, err := LoadHtpasswd(cfg.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		ret.users = users
+	}
+
+	for _, admin := range cfg.Admins {
+		ret.admins[admin] = true
+	}
+
+	for _, cidr := range cfg.ForwardIPs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ret.forwardIPs = append(ret.forwardIPs, ipnet)
+	}
+	if ret.forwardHeader != "" && len(ret.forwardIPs) == 0 {
+		// Anyone able to reach us could claim to be any user
+		return nil, fmt.Errorf("forwardips must be set to trust %s", ret.forwardHeader)
+	}
+
+	switch {
+	case cfg.Anonymous != "":
+		role, err := ParseRole(cfg.Anonymous)
+		if err != nil {
+			return nil, err
+		}
+		ret.anonymous = role
+	case len(ret.tokens) == 0 && ret.users == nil && ret.forwardHeader == "":
+		ret.anonymous = RoleViewer // nothing configured; open, as it has always been
+	default:
+		ret.anonymous = RoleNone
+	}
+
+	return ret, nil
+}
+
+func (s *Authenticator) Authenticate(r *http.Request) Identity {
+	if authz := r.Header.Get("Authorization"); len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+		token := strings.TrimSpace(authz[7:])
+		for known, role := range s.tokens {
+			if SecretEqual(token, known) {
+				return Identity{"token", role, "bearer"}
+			}
+		}
+		return Identity{Method: "bearer"}
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok && s.users != nil {
+		if s.users.Verify(user, pass) {
+			return Identity{user, s.userRole(user), "basic"}
+		}
+		return Identity{User: user, Method: "basic"}
+	}
+
+	if s.forwardHeader != "" && s.trustsForwarded(r) {
+		if user := r.Header.Get(s.forwardHeader); user != "" {
+			return Identity{user, s.userRole(user), "forward"}
+		}
+	}
+
+	return Identity{Role: s.anonymous, Method: "anonymous"}
+}
+
+func (s *Authenticator) userRole(user string) Role {
+	if s.admins[user] {
+		return RoleAdmin
+	}
+	return RoleViewer
+}
+
+func (s *Authenticator) trustsForwarded(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, ipnet := range s.forwardIPs {
+		if ip != nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// Wrap a handler so it is only served to requests with at least the given role. The
+// identity is available to the handler with FromContext
+func (s *Authenticator) Require(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := s.Authenticate(r)
+		if id.Role >= role {
+			next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+			return
+		}
+
+		if id.Role == RoleNone {
+			if s.users != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="lazyloader", charset="UTF-8"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logrus.Warnf("Denied %s %s to %s (%s)", r.Method, r.URL.Path, id.User, id.Role)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+}
+
+// Identity of a request served through Require
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
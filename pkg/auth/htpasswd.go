@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Users and password hashes, in htpasswd format. Supports apr1 (htpasswd's default) and
+// `$1$` md5-crypt, `{SHA}` and plain passwords. bcrypt (`htpasswd -B`) needs x/crypto,
+// which we don't have
+type Htpasswd map[string]string
+
+const (
+	shaPrefix  = "{SHA}"
+	apr1Prefix = "$apr1$"
+	md5Prefix  = "$1$"
+)
+
+// Parse `user:hash` lines, ignoring blanks and # comments
+func ParseHtpasswd(r io.Reader) (Htpasswd, error) {
+	ret := make(Htpasswd)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := ret.add(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	return ret, scanner.Err()
+}
+
+func LoadHtpasswd(path string) (Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+// Parse comma-separated `user:hash` entries (eg. from a label)
+func ParseHtpasswdList(list string) (Htpasswd, error) {
+	ret := make(Htpasswd)
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if err := ret.add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (s Htpasswd) add(entry string) error {
+	user, hash, ok := strings.Cut(entry, ":")
+	if !ok || user == "" {
+		return fmt.Errorf("expected user:hash")
+	}
+	if strings.HasPrefix(hash, "$") && !strings.HasPrefix(hash, apr1Prefix) && !strings.HasPrefix(hash, md5Prefix) {
+		return fmt.Errorf("unsupported hash for %s, use apr1 (htpasswd -m) or {SHA}", user)
+	}
+	s[user] = hash
+	return nil
+}
+
+// Check a user's password
+func (s Htpasswd) Verify(user, password string) bool {
+	hash, ok := s[user]
+	if !ok {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(hash, shaPrefix):
+		sum := sha1.Sum([]byte(password))
+		return SecretEqual(hash[len(shaPrefix):], base64.StdEncoding.EncodeToString(sum[:]))
+	case strings.HasPrefix(hash, apr1Prefix):
+		return SecretEqual(hash, md5Crypt(password, hash[len(apr1Prefix):], apr1Prefix))
+	case strings.HasPrefix(hash, md5Prefix):
+		return SecretEqual(hash, md5Crypt(password, hash[len(md5Prefix):], md5Prefix))
+	}
+	return SecretEqual(hash, password)
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// MD5-based crypt, as `magic + salt + "$" + hash` (magic is "$1$", or "$apr1$" for apache's
+// variant). salt may be followed by the rest of an existing hash
+func md5Crypt(password, salt, magic string) string {
+	if idx := strings.IndexByte(salt, '$'); idx >= 0 {
+		salt = salt[:idx]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.Sum([]byte(password + salt + password))
+	buf := []byte(password + magic + salt)
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			buf = append(buf, alt[:]...)
+		} else {
+			buf = append(buf, alt[:i]...)
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			buf = append(buf, 0)
+		} else {
+			buf = append(buf, password[0])
+		}
+	}
+	final := md5.Sum(buf)
+
+	// Slow it down
+	for i := 0; i < 1000; i++ {
+		buf = buf[:0]
+		if i&1 != 0 {
+			buf = append(buf, password...)
+		} else {
+			buf = append(buf, final[:]...)
+		}
+		if i%3 != 0 {
+			buf = append(buf, salt...)
+		}
+		if i%7 != 0 {
+			buf = append(buf, password...)
+		}
+		if i&1 != 0 {
+			buf = append(buf, final[:]...)
+		} else {
+			buf = append(buf, password...)
+		}
+		final = md5.Sum(buf)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic + salt + "$")
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, idx := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(final[idx[0]])<<16|uint32(final[idx[1]])<<8|uint32(final[idx[2]]), 4)
+	}
+	encode(uint32(final[11]), 2)
+	return out.String()
+}
+
+// Constant-time comparison of secrets
+func SecretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
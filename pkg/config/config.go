@@ -12,20 +12,90 @@ import (
 // Config model and loader
 
 type ConfigModel struct {
-	Listen     string // http listen
-	StopAtBoot bool   // Stop existing containers at start of app
-	Splash     string // Which splash page to serve
-	StatusHost string // Host that will serve the status page (empty is disabled)
+	Listen      string // http listen
+	StopAtBoot  bool   // Stop existing containers at start of app
+	Splash      string // Which splash page to serve
+	SplashDir   string // Directory to load templates and assets from, overriding embedded (empty is disabled)
+	StatusHost  string // Host that will serve the status page (empty is disabled)
+	AdminListen string // Separate listen for the status page, API, metrics and pprof (tcp, or unix:/path)
 
-	StopDelay time.Duration // Amount of time to wait before stopping a container
-	PollFreq  time.Duration // How often to check for changes
-	Timeout   time.Duration // Default operation timeout (eg. starting/stopping a container)
+	TCPListen string // TLS passthrough listen, matched on SNI (empty is disabled)
+	TCPMode   string // proxy or close
 
-	Verbose bool // Debug-level logging
+	StopDelay     time.Duration // Amount of time to wait before stopping a container
+	AdaptiveDelay bool          // Learn each container's stop delay from how soon it is woken after stopping
+	StopDelayMin  time.Duration // Bounds of adaptive stop delays
+	StopDelayMax  time.Duration
+	DrainMax      time.Duration // Longest to postpone an idle stop while connections are open
+	PollFreq      time.Duration // How often to check for changes
+
+	PrewarmLead      time.Duration // How long before predicted use to pre-start `prewarm` containers (0 is disabled)
+	PrewarmThreshold float64       // Fraction of weeks a weekday-hour must have been used in to be predicted
+	PrewarmFile      string        // File to persist wake history (empty is in-memory only)
+
+	PollParallelism int           // How many containers to check (or stop) at once while polling
+	StartHistory    int           // How many cold starts to remember per container, for latency stats
+	ActivityHistory int           // How many per-poll activity samples to keep per container (0 is disabled)
+	ActivityFile    string        // File to persist activity samples (empty is in-memory only)
+	Timeout         time.Duration // Default operation timeout (eg. starting/stopping a container)
+
+	MaxRunning      int            // Max lazy containers running at once (0 is unlimited)
+	GroupLimits     map[string]int // Max running per `group` label
+	LimitMode       string         // What to do when at a limit: "queue" or "evict"
+	StartsPerMinute int            // Default max starts per minute, per container (0 is unlimited)
+	MemoryBudget    string         // Max estimated memory of running containers, eg. "4g" (empty is unlimited)
+	MemoryDefault   string         // Memory estimate of containers without a limit or observed usage
+
+	Recreate    bool          // Capture container specs, and re-create removed containers (pulling images if needed)
+	SpecFile    string        // File to persist captured container specs (empty is in-memory only)
+	PullTimeout time.Duration // Timeout for pulling a missing image
+
+	CheckpointDir string // Host directory to store checkpoints in (empty is docker's default)
+
+	Coordination string        // When running multiple instances: "file" lease or "docker" label election (empty is disabled)
+	LeaseFile    string        // Lease file on a shared volume, for file coordination
+	LeaseTTL     time.Duration // How long a file lease is valid without renewal
+
+	Webhooks   []Webhook // Notified of lifecycle events
+	FlapStarts int       // Starts within FlapWindow for a container to be flapping (0 is disabled)
+	FlapWindow time.Duration
+
+	Wake       WakeRules  // Requests that won't wake containers
+	StatusAuth StatusAuth // Who can see the status page, and control containers
+
+	Verbose    bool   // Debug-level logging
+	LogFormat  string // text or json
+	LogHistory int    // How many recent log entries to keep for the status page (0 is disabled)
 
 	LabelPrefix string
 }
 
+type WakeRules struct {
+	DenyUserAgents []string // regexes
+	AllowIPs       []string // IPs or CIDRs; if any, only these can wake containers
+	DenyIPs        []string // IPs or CIDRs
+	DenyPaths      []string // Answered locally (eg. /robots.txt)
+	TrustForwarded bool     // Take the client IP from X-Forwarded-For (set by traefik)
+}
+
+type Webhook struct {
+	URL      string
+	Format   string            // json (default), slack, discord, ntfy or gotify
+	Events   []string          // Event types to send (empty is ready, stopped, failed and flapping)
+	Template string            // Body of json hooks, a text/template of the event
+	Headers  map[string]string // Extra request headers
+	Retries  int               // After the first attempt, with exponential backoff (0 is the default, 3)
+}
+
+type StatusAuth struct {
+	Tokens        []string // Bearer tokens, as `token` (viewer) or `admin:token`
+	Htpasswd      string   // File of users for basic auth
+	Admins        []string // Users with the admin role; others are viewers
+	ForwardHeader string   // Trust this header for the user (eg. X-Forwarded-User)
+	ForwardIPs    []string // CIDRs the forward header is trusted from (required with ForwardHeader)
+	Anonymous     string   // Role of unauthenticated requests (empty is automatic)
+}
+
 var Model *ConfigModel = new(ConfigModel)
 
 func Load() {
@@ -42,31 +42,64 @@ func (s *Discovery) FindAllLazyload(ctx context.Context, includeStopped bool) ([
 	}))
 }
 
-func (s *Discovery) FindContainerByHostname(ctx context.Context, hostname string) (*Wrapper, error) {
+// Matches a container's labels against a hostname
+type HostMatcher func(labels map[string]string, hostname string) bool
+
+// Finds the first lazyload container whose labels match the hostname
+func (s *Discovery) FindContainer(ctx context.Context, hostname string, matcher HostMatcher) (*Wrapper, error) {
 	containers, err := s.FindAllLazyload(ctx, true)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, c := range containers {
-		if hostStr, ok := c.Config("hosts"); ok {
-			hosts := strings.Split(hostStr, ",")
-			if strSliceContains(hosts, hostname) {
-				return &c, nil
-			}
-		} else {
-			// If not defined explicitely, infer from traefik route
-			for k, v := range c.Labels {
-				if strings.Contains(k, "traefik.http.routers.") && strings.Contains(v, hostname) { // TODO: More complex
-					return &c, nil
-				}
-			}
+		if matcher(c.Labels, hostname) {
+			return &c, nil
 		}
 	}
 
 	return nil, ErrNotFound
 }
 
+func (s *Discovery) FindContainerByHostname(ctx context.Context, hostname string) (*Wrapper, error) {
+	return s.FindContainer(ctx, hostname, MatchHost)
+}
+
+// Find a container by the SNI server name of a TLS connection
+func (s *Discovery) FindContainerBySNI(ctx context.Context, serverName string) (*Wrapper, error) {
+	return s.FindContainer(ctx, serverName, MatchSNI)
+}
+
+// Match explicit hosts, or infer from traefik http router
+func MatchHost(labels map[string]string, hostname string) bool {
+	if hostStr, ok := labels[config.SubLabel("hosts")]; ok {
+		return strSliceContains(strings.Split(hostStr, ","), hostname)
+	}
+
+	// If not defined explicitely, infer from traefik route
+	for k, v := range labels {
+		if strings.Contains(k, "traefik.http.routers.") && strings.Contains(v, hostname) { // TODO: More complex
+			return true
+		}
+	}
+	return false
+}
+
+// Match explicit hosts, or infer from traefik TCP router `HostSNI` rules
+func MatchSNI(labels map[string]string, serverName string) bool {
+	if hostStr, ok := labels[config.SubLabel("hosts")]; ok {
+		return strSliceContains(strings.Split(hostStr, ","), serverName)
+	}
+
+	for k, v := range labels {
+		if strings.HasPrefix(k, "traefik.tcp.routers.") && strings.HasSuffix(k, ".rule") &&
+			strSliceContains(parseHostSNIRule(v), serverName) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Discovery) FindDepProvider(ctx context.Context, name string) ([]Wrapper, error) {
 	filters := filters.NewArgs()
 	filters.Add("label", config.SubLabel("provides")+"="+name)
@@ -2,18 +2,42 @@ package containers
 
 import (
 	"context"
+	"io"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type Host interface {
+	Info(ctx context.Context) (types.Info, error)
+
 	ContainerList(ctx context.Context, clo types.ContainerListOptions) ([]types.Container, error)
 
 	ContainerStart(ctx context.Context, id string, opt types.ContainerStartOptions) error
 	ContainerStop(ctx context.Context, id string, opt container.StopOptions) error
+	ContainerPause(ctx context.Context, id string) error
+	ContainerUnpause(ctx context.Context, id string) error
+	ContainerKill(ctx context.Context, id, signal string) error
+	ContainerRemove(ctx context.Context, id string, opt types.ContainerRemoveOptions) error
+
+	CheckpointCreate(ctx context.Context, id string, opt types.CheckpointCreateOptions) error
+	CheckpointList(ctx context.Context, id string, opt types.CheckpointListOptions) ([]types.Checkpoint, error)
+	CheckpointDelete(ctx context.Context, id string, opt types.CheckpointDeleteOptions) error
+
+	ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error)
 
 	ContainerStatsOneShot(ctx context.Context, id string) (types.ContainerStats, error)
+	ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.CreateResponse, error)
+
+	NetworkConnect(ctx context.Context, network, id string, config *network.EndpointSettings) error
+
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
 
 	Close() error
 }
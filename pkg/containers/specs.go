@@ -0,0 +1,203 @@
+package containers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// Everything needed to re-create a container that has been removed
+type Spec struct {
+	ID         string                               `json:"id,omitempty"` // Container the spec was captured from
+	Name       string                               `json:"name"`
+	Config     *container.Config                    `json:"config"`
+	HostConfig *container.HostConfig                `json:"hostConfig,omitempty"`
+	Networks   map[string]*network.EndpointSettings `json:"networks,omitempty"`
+}
+
+// Capture a spec from a container inspect result, dropping runtime-assigned values
+func SpecFromInspect(info types.ContainerJSON) *Spec {
+	spec := &Spec{
+		ID:         info.ID,
+		Name:       strings.TrimPrefix(info.Name, "/"),
+		Config:     info.Config,
+		HostConfig: info.HostConfig,
+		Networks:   make(map[string]*network.EndpointSettings),
+	}
+
+	if spec.Config != nil && len(info.ID) >= 12 && spec.Config.Hostname == info.ID[:12] {
+		// Docker defaults the hostname to the short ID; let it assign a new one
+		cfg := *spec.Config
+		cfg.Hostname = ""
+		spec.Config = &cfg
+	}
+
+	if info.NetworkSettings != nil {
+		for name, ep := range info.NetworkSettings.Networks {
+			if ep == nil {
+				continue
+			}
+			var aliases []string
+			for _, alias := range ep.Aliases {
+				if !strings.HasPrefix(info.ID, alias) {
+					aliases = append(aliases, alias)
+				}
+			}
+			spec.Networks[name] = &network.EndpointSettings{
+				IPAMConfig: ep.IPAMConfig,
+				Links:      ep.Links,
+				Aliases:    aliases,
+				DriverOpts: ep.DriverOpts,
+			}
+		}
+	}
+
+	return spec
+}
+
+// Wrap a spec as a container that doesn't exist yet
+func (s *Spec) Wrapper() *Wrapper {
+	ret := &Wrapper{}
+	ret.Names = []string{"/" + s.Name}
+	ret.State = "missing"
+	if s.Config != nil {
+		ret.Image = s.Config.Image
+		ret.Labels = s.Config.Labels
+	}
+	return ret
+}
+
+func (s *Spec) Image() string {
+	if s.Config == nil {
+		return ""
+	}
+	return s.Config.Image
+}
+
+// Network names, with the primary (HostConfig.NetworkMode) first
+func (s *Spec) NetworkNames() []string {
+	var primary string
+	if s.HostConfig != nil {
+		primary = string(s.HostConfig.NetworkMode)
+	}
+
+	names := make([]string, 0, len(s.Networks))
+	for name := range s.Networks {
+		if name != primary {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := s.Networks[primary]; ok {
+		names = append([]string{primary}, names...)
+	}
+	return names
+}
+
+// Container specs by name, optionally persisted to a JSON file
+type SpecStore struct {
+	mux     sync.Mutex
+	saveMux sync.Mutex // serializes writing path (and its .tmp)
+	path    string
+	specs   map[string]*Spec
+}
+
+// Create a spec store, loading existing (or hand-declared) specs from path if it exists.
+// An empty path keeps specs in memory only
+func NewSpecStore(path string) (*SpecStore, error) {
+	ret := &SpecStore{
+		path:  path,
+		specs: make(map[string]*Spec),
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			var specs []*Spec
+			if err := json.Unmarshal(data, &specs); err != nil {
+				return nil, err
+			}
+			for _, spec := range specs {
+				ret.specs[spec.Name] = spec
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// Returns true if a spec has already been captured for this container
+func (s *SpecStore) Has(name, id string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	spec, ok := s.specs[name]
+	return ok && spec.ID == id
+}
+
+func (s *SpecStore) Put(spec *Spec) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.specs[spec.Name] = spec
+}
+
+func (s *SpecStore) Get(name string) *Spec {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.specs[name]
+}
+
+// Find the spec whose labels match the hostname
+func (s *SpecStore) Find(hostname string, matcher HostMatcher) *Spec {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, spec := range s.specs {
+		if spec.Config != nil && matcher(spec.Config.Labels, hostname) {
+			return spec
+		}
+	}
+	return nil
+}
+
+// Persist specs to disk, if a path was given
+func (s *SpecStore) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.saveMux.Lock()
+	defer s.saveMux.Unlock()
+	s.mux.Lock()
+	specs := make([]*Spec, 0, len(s.specs))
+	for _, spec := range s.specs {
+		specs = append(specs, spec)
+	}
+	s.mux.Unlock()
+
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+
+	data, err := json.MarshalIndent(specs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
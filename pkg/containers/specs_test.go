@@ -0,0 +1,80 @@
+package containers
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpecFromInspect(t *testing.T) {
+	const id = "0123456789abcdef"
+	spec := SpecFromInspect(types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Name:       "/whoami",
+			HostConfig: &container.HostConfig{NetworkMode: "web"},
+		},
+		Config: &container.Config{Hostname: id[:12], Image: "containous/whoami"},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"web":   {Aliases: []string{"whoami", id[:12]}, IPAddress: "172.0.0.2"},
+				"other": {},
+			},
+		},
+	})
+
+	assert.Equal(t, "whoami", spec.Name)
+	assert.Equal(t, "containous/whoami", spec.Image())
+	assert.Empty(t, spec.Config.Hostname)
+	assert.Equal(t, []string{"whoami"}, spec.Networks["web"].Aliases)
+	assert.Empty(t, spec.Networks["web"].IPAddress)
+	assert.Equal(t, []string{"web", "other"}, spec.NetworkNames())
+}
+
+func TestSpecStorePersist(t *testing.T) {
+	config.Model.LabelPrefix = "lazyloader"
+	path := filepath.Join(t.TempDir(), "specs.json")
+
+	store, err := NewSpecStore(path)
+	assert.NoError(t, err)
+	store.Put(&Spec{ID: "abc", Name: "whoami", Config: &container.Config{
+		Labels: map[string]string{"lazyloader.hosts": "whoami.example.com"},
+	}})
+	assert.NoError(t, store.Save())
+
+	loaded, err := NewSpecStore(path)
+	assert.NoError(t, err)
+	assert.True(t, loaded.Has("whoami", "abc"))
+	assert.NotNil(t, loaded.Find("whoami.example.com", MatchHost))
+	assert.Nil(t, loaded.Find("other.example.com", MatchHost))
+}
+
+func TestSpecStoreConcurrentSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "specs.json")
+	store, err := NewSpecStore(path)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store.Put(&Spec{ID: fmt.Sprint(i), Name: fmt.Sprintf("app%d", i)})
+			assert.NoError(t, store.Save())
+		}(i)
+	}
+	wg.Wait()
+
+	loaded, err := NewSpecStore(path)
+	assert.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		assert.True(t, loaded.Has(fmt.Sprintf("app%d", i), fmt.Sprint(i)))
+	}
+}
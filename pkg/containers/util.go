@@ -1,5 +1,7 @@
 package containers
 
+import "strings"
+
 func strSliceContains(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -8,3 +10,28 @@ func strSliceContains(slice []string, s string) bool {
 	}
 	return false
 }
+
+// Extracts all hostnames from the `HostSNI(...)` matchers of a traefik TCP rule
+// eg. "HostSNI(`a.com`, `b.com`) || HostSNI(`c.com`)" -> [a.com b.com c.com]
+func parseHostSNIRule(rule string) (hosts []string) {
+	const matcher = "HostSNI("
+	for {
+		idx := strings.Index(rule, matcher)
+		if idx < 0 {
+			return
+		}
+		rule = rule[idx+len(matcher):]
+
+		end := strings.IndexByte(rule, ')')
+		if end < 0 {
+			return
+		}
+		for _, arg := range strings.Split(rule[:end], ",") {
+			arg = strings.Trim(strings.TrimSpace(arg), "`\"'")
+			if arg != "" && arg != "*" {
+				hosts = append(hosts, arg)
+			}
+		}
+		rule = rule[end:]
+	}
+}
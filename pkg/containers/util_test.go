@@ -10,3 +10,10 @@ func TestSliceContainers(t *testing.T) {
 	assert.True(t, strSliceContains([]string{"hello", "thar"}, "thar"))
 	assert.False(t, strSliceContains([]string{"hello", "thar"}, "th"))
 }
+
+func TestParseHostSNIRule(t *testing.T) {
+	assert.Equal(t, []string{"a.com"}, parseHostSNIRule("HostSNI(`a.com`)"))
+	assert.Equal(t, []string{"a.com", "b.com", "c.com"}, parseHostSNIRule("HostSNI(`a.com`, `b.com`) || HostSNI(`c.com`)"))
+	assert.Empty(t, parseHostSNIRule("HostSNI(`*`)"))
+	assert.Empty(t, parseHostSNIRule("Host(`a.com`)"))
+}
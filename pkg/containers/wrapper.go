@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/logging"
 
 	"github.com/docker/docker/api/types"
 	"github.com/sirupsen/logrus"
@@ -17,24 +18,50 @@ type Wrapper struct {
 	types.Container
 }
 
+// Container name, or image if it has none
+func (s *Wrapper) Name() string {
+	if len(s.Names) > 0 {
+		return strings.TrimPrefix(s.Names[0], "/")
+	}
+	return s.Image
+}
+
 // Human-consumable name + ID
 func (s *Wrapper) NameID() string {
-	var name string
-	if len(s.Names) > 0 {
-		name = strings.TrimPrefix(s.Names[0], "/")
-	} else {
-		name = s.Image
+	if s.ID == "" { // not created yet
+		return s.Name()
 	}
-	return fmt.Sprintf("%s (%s)", name, s.ShortId())
+	return fmt.Sprintf("%s (%s)", s.Name(), s.ShortId())
 }
 
 // char-len capped ID
 func (s *Wrapper) ShortId() string {
+	return shortID(s.ID)
+}
+
+func shortID(id string) string {
 	const SLEN = 8
-	if len(s.ID) <= SLEN {
-		return s.ID
+	if len(id) <= SLEN {
+		return id
 	}
-	return s.ID[:SLEN]
+	return id[:SLEN]
+}
+
+// Logger with the container's ID and name
+func (s *Wrapper) Log() *logrus.Entry {
+	return Logger(s.ID, s.Name())
+}
+
+// Logger for a container, by ID and name (either may be empty, if not known)
+func Logger(id, name string) *logrus.Entry {
+	fields := make(logrus.Fields, 2)
+	if id != "" {
+		fields[logging.FieldContainerID] = shortID(id)
+	}
+	if name != "" {
+		fields[logging.FieldContainerName] = name
+	}
+	return logrus.WithFields(fields)
 }
 
 // Returns config labels with the prefix trimmed
@@ -77,13 +104,27 @@ func (s *Wrapper) ConfigInt(sublabel string, dflt int) (int, bool) {
 	}
 
 	if ival, err := strconv.Atoi(val); err != nil {
-		logrus.Warnf("Unable to parse %s on %s: %v. Using default of %d", sublabel, s.NameID(), err, dflt)
+		s.Log().Warnf("Unable to parse %s: %v. Using default of %d", sublabel, err, dflt)
 		return dflt, false
 	} else {
 		return ival, true
 	}
 }
 
+func (s *Wrapper) ConfigBool(sublabel string, dflt bool) (bool, bool) {
+	val, ok := s.Config(sublabel)
+	if !ok {
+		return dflt, false
+	}
+
+	if bval, err := strconv.ParseBool(val); err != nil {
+		s.Log().Warnf("Unable to parse %s: %v. Using default of %t", sublabel, err, dflt)
+		return dflt, false
+	} else {
+		return bval, true
+	}
+}
+
 func (s *Wrapper) ConfigDuration(sublabel string, dflt time.Duration) (time.Duration, bool) {
 	val, ok := s.Config(sublabel)
 	if !ok {
@@ -91,18 +132,35 @@ func (s *Wrapper) ConfigDuration(sublabel string, dflt time.Duration) (time.Dura
 	}
 
 	if dur, err := time.ParseDuration(val); err != nil {
-		logrus.Warnf("Unable to parse %s on %s: %v. Using default of %s", sublabel, s.NameID(), err, dflt.String())
+		s.Log().Warnf("Unable to parse %s: %v. Using default of %s", sublabel, err, dflt.String())
 		return dflt, false
 	} else {
 		return dur, true
 	}
 }
 
+// Infers the backend port from traefik TCP service labels, or 0 if none
+func (s *Wrapper) TraefikTCPPort() int {
+	for k, v := range s.Labels {
+		if strings.HasPrefix(k, "traefik.tcp.services.") && strings.HasSuffix(k, ".loadbalancer.server.port") {
+			if port, err := strconv.Atoi(v); err == nil {
+				return port
+			}
+		}
+	}
+	return 0
+}
+
 // true if state is running
 func (s *Wrapper) IsRunning() bool {
 	return s.State == "running"
 }
 
+// true if state is paused
+func (s *Wrapper) IsPaused() bool {
+	return s.State == "paused"
+}
+
 // Wrap a container set
 func wrapContainers(cts ...types.Container) []Wrapper {
 	ret := make([]Wrapper, len(cts))
@@ -0,0 +1,88 @@
+package coordination
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+)
+
+// Elects the oldest running container with the group label as leader. Needs no
+// shared storage, but each instance must run in a container carrying the label
+// (eg. `lazyloader.instance=main`), with its hostname left as the container ID
+type DockerElector struct {
+	client containers.Host
+	label  string // key=value
+
+	mux    sync.Mutex
+	leader bool
+}
+
+var _ Elector = &DockerElector{}
+
+func NewDockerElector(client containers.Host, label string) *DockerElector {
+	return &DockerElector{
+		client: client,
+		label:  label,
+	}
+}
+
+func (s *DockerElector) IsLeader(ctx context.Context) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	leader, err := s.elect(ctx)
+	if err != nil {
+		logrus.Warnf("Unable to elect leader: %v", err)
+		leader = false
+	}
+
+	if leader != s.leader {
+		if leader {
+			logrus.Info("Elected as leader")
+		} else {
+			logrus.Info("No longer leader")
+		}
+		s.leader = leader
+	}
+	return leader
+}
+
+func (s *DockerElector) elect(ctx context.Context) (bool, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return false, err
+	}
+
+	filters := filters.NewArgs()
+	filters.Add("label", s.label)
+	instances, err := s.client.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters,
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(instances) == 0 {
+		logrus.Warnf("No running containers with label %s; is this instance labeled?", s.label)
+		return false, nil
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Created != instances[j].Created {
+			return instances[i].Created < instances[j].Created
+		}
+		return instances[i].ID < instances[j].ID
+	})
+
+	return strings.HasPrefix(instances[0].ID, hostname), nil
+}
+
+func (s *DockerElector) Close() error {
+	return nil
+}
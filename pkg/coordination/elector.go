@@ -0,0 +1,25 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Decides which of several lazyloader instances runs the poll/stop loop
+type Elector interface {
+	// Renew or try to acquire leadership, returning true if this instance is the leader
+	IsLeader(ctx context.Context) bool
+
+	// Give up leadership, if held
+	Close() error
+}
+
+// Identifies this instance. In docker, the hostname defaults to the container's short ID
+func InstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
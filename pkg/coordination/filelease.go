@@ -0,0 +1,143 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type leaseRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// Leader lease stored in a file on a volume shared by all instances. The holder
+// renews it on each check; others take over once it expires. Checks are serialized
+// across instances by an exclusive lock on a `.lock` file next to it
+type FileLease struct {
+	path string
+	id   string
+	ttl  time.Duration
+
+	mux    sync.Mutex
+	leader bool
+}
+
+var _ Elector = &FileLease{}
+
+func NewFileLease(path, id string, ttl time.Duration) *FileLease {
+	return &FileLease{
+		path: path,
+		id:   id,
+		ttl:  ttl,
+	}
+}
+
+func (s *FileLease) IsLeader(ctx context.Context) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	leader, err := s.tryAcquire()
+	if err != nil {
+		logrus.Warnf("Unable to acquire lease %s: %v", s.path, err)
+		leader = false
+	}
+
+	if leader != s.leader {
+		if leader {
+			logrus.Infof("Acquired leader lease %s as %s", s.path, s.id)
+		} else {
+			logrus.Infof("Lost leader lease %s", s.path)
+		}
+		s.leader = leader
+	}
+	return leader
+}
+
+func (s *FileLease) tryAcquire() (bool, error) {
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current, err := s.read()
+	if err != nil {
+		return false, err
+	}
+	if current != nil && current.Holder != s.id && time.Now().Before(current.Expires) {
+		return false, nil
+	}
+
+	if err := s.write(leaseRecord{s.id, time.Now().Add(s.ttl)}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *FileLease) read() (*leaseRecord, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var record leaseRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, nil // corrupt; treat as free
+	}
+	return &record, nil
+}
+
+func (s *FileLease) write(record leaseRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".lease-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Release the lease, so another instance can take over immediately
+func (s *FileLease) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.leader {
+		return nil
+	}
+	s.leader = false
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := s.read()
+	if err != nil || current == nil || current.Holder != s.id {
+		return err
+	}
+	return s.write(leaseRecord{Holder: s.id}) // expired
+}
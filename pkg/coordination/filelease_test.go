@@ -0,0 +1,62 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLease(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lease")
+
+	a := NewFileLease(path, "a", time.Hour)
+	b := NewFileLease(path, "b", time.Hour)
+
+	assert.True(t, a.IsLeader(ctx))
+	assert.False(t, b.IsLeader(ctx))
+	assert.True(t, a.IsLeader(ctx)) // renew
+
+	// Releasing hands over immediately
+	assert.NoError(t, a.Close())
+	assert.True(t, b.IsLeader(ctx))
+	assert.False(t, a.IsLeader(ctx))
+}
+
+func TestFileLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lease")
+
+	a := NewFileLease(path, "a", 10*time.Millisecond)
+	b := NewFileLease(path, "b", time.Hour)
+
+	assert.True(t, a.IsLeader(ctx))
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, b.IsLeader(ctx))
+	assert.False(t, a.IsLeader(ctx))
+}
+
+func TestFileLeaseOneLeaderWhenContended(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lease")
+
+	var wg sync.WaitGroup
+	var leaders int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if NewFileLease(path, fmt.Sprintf("i%d", i), time.Hour).IsLeader(ctx) {
+				atomic.AddInt32(&leaders, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), leaders)
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package coordination
+
+import (
+	"os"
+	"syscall"
+)
+
+// Take an exclusive lock on a file (created if missing), blocking until it is held.
+// Returns a func to release it. The lock is released by the OS if the process dies
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
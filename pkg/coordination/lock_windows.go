@@ -0,0 +1,7 @@
+package coordination
+
+import "errors"
+
+func lockFile(path string) (func(), error) {
+	return nil, errors.New("file coordination isn't supported on windows")
+}
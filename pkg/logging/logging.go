@@ -0,0 +1,146 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields attached to log entries
+const (
+	FieldContainerID   = "container_id"
+	FieldContainerName = "container_name"
+	FieldHost          = "host"
+	FieldEvent         = "event"
+	FieldDuration      = "duration" // seconds
+	FieldRequestID     = "request_id"
+	FieldDependency    = "dependency"
+	FieldUser          = "user"   // who asked for an admin action
+	FieldAction        = "action" // admin action, eg. stop
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Set the format of the global logger
+func SetFormat(format string) error {
+	switch format {
+	case "", FormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case FormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+type requestIDKey struct{}
+
+// Attach a request ID to a context, so what the request starts is logged with it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// ID of the request a context is for (empty if none)
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// A random ID for a request that didn't come with one
+func NewRequestID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// A log entry, as kept by Recent
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Fields as "key=value" pairs, ordered by key
+func (s *Entry) FieldList() []string {
+	ret := make([]string, 0, len(s.Fields))
+	for key, val := range s.Fields {
+		ret = append(ret, key+"="+val)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Keeps the most recent log entries in memory, as a logrus hook
+type Recent struct {
+	mux     sync.Mutex
+	entries []Entry // ring
+	next    int
+	full    bool
+}
+
+// Keep up to size entries; nothing is kept if size isn't positive
+func NewRecent(size int) *Recent {
+	if size < 0 {
+		size = 0
+	}
+	return &Recent{entries: make([]Entry, size)}
+}
+
+func (s *Recent) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *Recent) Fire(entry *logrus.Entry) error {
+	if len(s.entries) == 0 {
+		return nil
+	}
+
+	kept := Entry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if len(entry.Data) > 0 {
+		kept.Fields = make(map[string]string, len(entry.Data))
+		for key, val := range entry.Data {
+			kept.Fields[key] = fmt.Sprint(val)
+		}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.entries[s.next] = kept
+	s.next = (s.next + 1) % len(s.entries)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+// Kept entries, newest first
+func (s *Recent) Entries() []Entry {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	count := s.next
+	if s.full {
+		count = len(s.entries)
+	}
+	ret := make([]Entry, 0, count)
+	for i := 1; i <= count; i++ {
+		ret = append(ret, s.entries[(s.next-i+len(s.entries))%len(s.entries)])
+	}
+	return ret
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentKeepsNewest(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(&bytes.Buffer{})
+	recent := NewRecent(2)
+	logger.AddHook(recent)
+
+	logger.Info("one")
+	logger.WithField(FieldContainerName, "app").Warn("two")
+	logger.Info("three")
+
+	entries := recent.Entries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "three", entries[0].Message)
+	assert.Equal(t, "two", entries[1].Message)
+	assert.Equal(t, "warning", entries[1].Level)
+	assert.Equal(t, []string{"container_name=app"}, entries[1].FieldList())
+}
+
+func TestRecentEmpty(t *testing.T) {
+	assert.Empty(t, NewRecent(3).Entries())
+	assert.NoError(t, NewRecent(0).Fire(logrus.NewEntry(logrus.New())))
+	assert.NoError(t, NewRecent(-1).Fire(logrus.NewEntry(logrus.New())))
+}
+
+func TestSetFormat(t *testing.T) {
+	defer logrus.SetFormatter(&logrus.TextFormatter{})
+	var out bytes.Buffer
+	logrus.SetOutput(&out)
+	defer logrus.SetOutput(logrus.New().Out)
+
+	assert.NoError(t, SetFormat(FormatJSON))
+	logrus.WithField(FieldHost, "a.example.com").Info("hello")
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "hello", line["msg"])
+	assert.Equal(t, "a.example.com", line["host"])
+
+	assert.Error(t, SetFormat("xml"))
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "abc")
+	assert.Equal(t, "abc", RequestID(ctx))
+	assert.Equal(t, "", RequestID(context.Background()))
+	assert.Len(t, NewRequestID(), 16)
+}
@@ -0,0 +1,269 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"traefik-lazyload/pkg/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Body formats of a webhook
+const (
+	FormatJSON    = "json" // the event as JSON, or the hook's template
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+	FormatNtfy    = "ntfy"
+	FormatGotify  = "gotify"
+)
+
+// Events sent to hooks that don't list any
+var DefaultEvents = []service.EventType{service.EventReady, service.EventStopped, service.EventFailed, service.EventFlapping}
+
+type Hook struct {
+	URL      string
+	Format   string            // json (default), slack, discord, ntfy or gotify
+	Events   []string          // event types to send (empty is DefaultEvents)
+	Template string            // body of json hooks, a text/template of the event (see templateFuncs)
+	Headers  map[string]string // extra request headers (eg. Authorization)
+	Retries  int               // after the first attempt, with exponential backoff
+}
+
+const (
+	queueLen       = 64
+	defaultRetries = 3
+)
+
+// Extra functions for templates. Values aren't escaped, so strings should go through `json`
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+type hookSender struct {
+	Hook
+	events map[service.EventType]bool
+	tmpl   *template.Template
+	queue  chan service.Event
+}
+
+// Sends lifecycle events to webhooks. Each hook sends in order, in the background
+type Notifier struct {
+	hooks   []*hookSender
+	client  *http.Client
+	backoff time.Duration // before the first retry, doubling after
+	done    chan struct{}
+}
+
+func New(hooks []Hook) (*Notifier, error) {
+	ret := &Notifier{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+		done:    make(chan struct{}),
+	}
+
+	for i, hook := range hooks {
+		sender := &hookSender{
+			Hook:   hook,
+			events: make(map[service.EventType]bool),
+			queue:  make(chan service.Event, queueLen),
+		}
+		if sender.URL == "" {
+			return nil, fmt.Errorf("webhook %d: missing url", i)
+		}
+		switch sender.Format {
+		case "":
+			sender.Format = FormatJSON
+		case FormatJSON, FormatSlack, FormatDiscord, FormatNtfy, FormatGotify:
+		default:
+			return nil, fmt.Errorf("webhook %d: unknown format %q", i, sender.Format)
+		}
+		if sender.Template != "" {
+			tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(sender.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %d: %w", i, err)
+			}
+			sender.tmpl = tmpl
+		}
+		if sender.Retries <= 0 {
+			sender.Retries = defaultRetries
+		}
+
+		events := DefaultEvents
+		if len(sender.Events) > 0 {
+			events = nil
+			for _, ev := range sender.Events {
+				events = append(events, service.EventType(strings.ToLower(ev)))
+			}
+		}
+		for _, ev := range events {
+			sender.events[ev] = true
+		}
+
+		ret.hooks = append(ret.hooks, sender)
+	}
+
+	for _, hook := range ret.hooks {
+		go ret.run(hook)
+	}
+	return ret, nil
+}
+
+// Queue an event for the hooks that want it. If a hook is too far behind, it's dropped
+func (s *Notifier) Notify(ev service.Event) {
+	for _, hook := range s.hooks {
+		if !hook.events[ev.Type] {
+			continue
+		}
+		select {
+		case hook.queue <- ev:
+		default:
+			logrus.Warnf("Webhook %s is behind, dropping %s event of %s", hook.URL, ev.Type, ev.Name)
+		}
+	}
+}
+
+// Notify events from a channel (eg. Core.Subscribe), until it's closed or the notifier is
+func (s *Notifier) Forward(events <-chan service.Event) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			s.Notify(ev)
+		}
+	}
+}
+
+// Stop sending. Queued events are dropped
+func (s *Notifier) Close() {
+	close(s.done)
+}
+
+func (s *Notifier) run(hook *hookSender) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case ev := <-hook.queue:
+			if err := s.send(hook, ev); err != nil {
+				logrus.Warnf("Unable to send %s event of %s to webhook %s: %v", ev.Type, ev.Name, hook.URL, err)
+			}
+		}
+	}
+}
+
+// Send an event, retrying with backoff on network errors, 429s and 5xxs
+func (s *Notifier) send(hook *hookSender, ev service.Event) error {
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(hook, ev)
+		if err == nil || !retry || attempt >= hook.Retries {
+			return err
+		}
+		logrus.Debugf("Webhook %s failed (%v), retrying in %s", hook.URL, err, backoff)
+		select {
+		case <-s.done:
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *Notifier) post(hook *hookSender, ev service.Event) (retry bool, err error) {
+	req, err := hook.request(ev)
+	if err != nil {
+		return false, err
+	}
+	for key, val := range hook.Headers {
+		req.Header.Set(key, val)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Human summary of an event, for chat formats
+func summary(ev service.Event) string {
+	return fmt.Sprintf("%s: %s", ev.Name, ev.Message)
+}
+
+func (s *hookSender) request(ev service.Event) (*http.Request, error) {
+	var body interface{}
+	switch s.Format {
+	case FormatSlack:
+		body = map[string]string{"text": summary(ev)}
+	case FormatDiscord:
+		body = map[string]string{"content": summary(ev)}
+	case FormatGotify:
+		body = map[string]interface{}{"title": fmt.Sprintf("%s %s", ev.Name, ev.Type), "message": ev.Message, "priority": priority(ev)}
+	case FormatNtfy:
+		req, err := http.NewRequest(http.MethodPost, s.URL, strings.NewReader(ev.Message))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", fmt.Sprintf("%s %s", ev.Name, ev.Type))
+		req.Header.Set("Tags", string(ev.Type))
+		req.Header.Set("Priority", fmt.Sprint(priority(ev)))
+		return req, nil
+	default:
+		if s.tmpl != nil {
+			var buf bytes.Buffer
+			if err := s.tmpl.Execute(&buf, ev); err != nil {
+				return nil, err
+			}
+			if !json.Valid(buf.Bytes()) {
+				return nil, fmt.Errorf("template rendered invalid JSON: %s", buf.String())
+			}
+			return jsonRequest(s.URL, buf.Bytes())
+		}
+		body = ev
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return jsonRequest(s.URL, data)
+}
+
+func jsonRequest(url string, data []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// Priority of an event, on ntfy's 1-5 scale (also fine for gotify)
+func priority(ev service.Event) int {
+	switch ev.Type {
+	case service.EventFailed, service.EventFlapping:
+		return 4
+	default:
+		return 3
+	}
+}
@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type received struct {
+	header http.Header
+	body   string
+}
+
+// Local webhook receiver, failing the first `failures` requests
+func newReceiver(t *testing.T, failures int) (*httptest.Server, <-chan received) {
+	ch := make(chan received, 16)
+	var mux sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		ch <- received{r.Header, string(body)}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, ch
+}
+
+func newTestNotifier(t *testing.T, hooks ...Hook) *Notifier {
+	notifier, err := New(hooks)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	notifier.backoff = time.Millisecond
+	t.Cleanup(notifier.Close)
+	return notifier
+}
+
+func receive(t *testing.T, ch <-chan received) received {
+	select {
+	case ret := <-ch:
+		return ret
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not received")
+		return received{}
+	}
+}
+
+var readyEvent = service.Event{Type: service.EventReady, Name: "web", Message: "Container is ready"}
+
+func TestWebhookFormats(t *testing.T) {
+	srv, ch := newReceiver(t, 0)
+	notifier := newTestNotifier(t,
+		Hook{URL: srv.URL + "/json"},
+		Hook{URL: srv.URL + "/slack", Format: FormatSlack},
+		Hook{URL: srv.URL + "/discord", Format: FormatDiscord, Headers: map[string]string{"X-Test": "yes"}},
+	)
+
+	notifier.Notify(readyEvent)
+	bodies := make(map[string]received)
+	for i := 0; i < 3; i++ {
+		got := receive(t, ch)
+		var fields map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(got.body), &fields))
+		switch {
+		case fields["text"] != nil:
+			bodies["slack"] = got
+		case fields["content"] != nil:
+			bodies["discord"] = got
+		default:
+			bodies["json"] = got
+		}
+	}
+	assert.Contains(t, bodies["json"].body, `"type":"ready"`)
+	assert.Equal(t, "application/json", bodies["json"].header.Get("Content-Type"))
+	assert.Contains(t, bodies["slack"].body, "web: Container is ready")
+	assert.Equal(t, "yes", bodies["discord"].header.Get("X-Test"))
+}
+
+func TestWebhookNtfyAndTemplate(t *testing.T) {
+	srv, ch := newReceiver(t, 0)
+	notifier := newTestNotifier(t, Hook{URL: srv.URL, Format: FormatNtfy})
+	notifier.Notify(service.Event{Type: service.EventFailed, Name: "web", Message: "boom"})
+	got := receive(t, ch)
+	assert.Equal(t, "boom", got.body)
+	assert.Equal(t, "web failed", got.header.Get("Title"))
+	assert.Equal(t, "4", got.header.Get("Priority"))
+
+	notifier = newTestNotifier(t, Hook{URL: srv.URL, Template: `{"msg": "{{.Name}} is {{.Type}}"}`})
+	notifier.Notify(readyEvent)
+	assert.Equal(t, `{"msg": "web is ready"}`, receive(t, ch).body)
+
+	notifier = newTestNotifier(t, Hook{URL: srv.URL, Template: `{"msg": {{json .Message}}}`})
+	notifier.Notify(service.Event{Type: service.EventFailed, Name: "web", Message: "exec \"run\" failed:\nboom"})
+	assert.Equal(t, `{"msg": "exec \"run\" failed:\nboom"}`, receive(t, ch).body)
+
+	// Not sent if unescaped values break the JSON
+	notifier = newTestNotifier(t, Hook{URL: srv.URL, Template: `{"msg": "{{.Message}}"}`})
+	_, err := notifier.post(notifier.hooks[0], service.Event{Type: service.EventFailed, Name: "web", Message: `"boom"`})
+	assert.Error(t, err)
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	srv, ch := newReceiver(t, 0)
+	notifier := newTestNotifier(t, Hook{URL: srv.URL, Events: []string{"Stopped"}})
+	notifier.Notify(readyEvent)
+	notifier.Notify(service.Event{Type: service.EventStopped, Name: "web"})
+	assert.Contains(t, receive(t, ch).body, `"type":"stopped"`)
+	assert.Len(t, ch, 0)
+}
+
+func TestWebhookRetry(t *testing.T) {
+	srv, ch := newReceiver(t, 2)
+	notifier := newTestNotifier(t, Hook{URL: srv.URL})
+	notifier.Notify(readyEvent)
+	assert.Contains(t, receive(t, ch).body, `"name":"web"`)
+
+	// Gives up after the retries
+	srv, ch = newReceiver(t, 5)
+	notifier = newTestNotifier(t, Hook{URL: srv.URL, Retries: 1})
+	err := notifier.send(notifier.hooks[0], readyEvent)
+	assert.Error(t, err)
+	assert.Len(t, ch, 0)
+}
+
+func TestWebhookInvalid(t *testing.T) {
+	_, err := New([]Hook{{URL: "http://x", Format: "pager"}})
+	assert.Error(t, err)
+	_, err = New([]Hook{{Format: FormatSlack}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Usage of a container over one poll
+type ActivitySample struct {
+	Time   time.Time `json:"time"`
+	Rx     int64     `json:"rx"`     // bytes received since the previous sample
+	Tx     int64     `json:"tx"`     // bytes sent since the previous sample
+	CPU    float64   `json:"cpu"`    // percent of one core, averaged since the previous sample
+	Memory int64     `json:"memory"` // bytes in use
+}
+
+// Cumulative counters of the latest stats, to take deltas against
+type activityCounters struct {
+	id             string
+	rx, tx         int64
+	cpu, systemCPU uint64
+}
+
+// Bounded history of per-poll samples, per container name, optionally persisted to a file
+type activityTracker struct {
+	size int
+	path string
+
+	saveMux  sync.Mutex // serializes writing path (and its .tmp)
+	mux      sync.Mutex
+	samples  map[string][]ActivitySample
+	counters map[string]activityCounters
+	saved    time.Time
+}
+
+// How often the history is written to its file, at most
+const activitySaveInterval = time.Minute
+
+func newActivityTracker(size int, path string) (*activityTracker, error) {
+	ret := &activityTracker{
+		size:     size,
+		path:     path,
+		samples:  make(map[string][]ActivitySample),
+		counters: make(map[string]activityCounters),
+		saved:    time.Now(),
+	}
+
+	if path != "" && size > 0 {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &ret.samples); err != nil {
+				return nil, err
+			}
+			for name, samples := range ret.samples {
+				if len(samples) > size {
+					ret.samples[name] = samples[len(samples)-size:]
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *activityTracker) enabled() bool {
+	return s.size > 0
+}
+
+// Record a sample from a container's stats. The first stats of a container (or of a
+// re-created one) only set the baseline for the deltas
+func (s *activityTracker) observe(name, id string, stats *types.StatsJSON) {
+	if !s.enabled() {
+		return
+	}
+
+	rx, tx := sumNetworkBytes(stats.Networks)
+	now := activityCounters{id, rx, tx, stats.CPUStats.CPUUsage.TotalUsage, stats.CPUStats.SystemUsage}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	prev, ok := s.counters[name]
+	s.counters[name] = now
+	if !ok || prev.id != id {
+		return
+	}
+
+	sample := ActivitySample{
+		Time:   time.Now(),
+		Rx:     counterDelta(prev.rx, now.rx),
+		Tx:     counterDelta(prev.tx, now.tx),
+		Memory: int64(stats.MemoryStats.Usage),
+	}
+	if now.systemCPU > prev.systemCPU && now.cpu >= prev.cpu {
+		cpus := stats.CPUStats.OnlineCPUs
+		if cpus == 0 {
+			cpus = uint32(len(stats.CPUStats.CPUUsage.PercpuUsage))
+		}
+		sample.CPU = float64(now.cpu-prev.cpu) / float64(now.systemCPU-prev.systemCPU) * float64(cpus) * 100
+	}
+
+	samples := s.samples[name]
+	if len(samples) >= s.size {
+		samples = samples[1:]
+	}
+	s.samples[name] = append(samples, sample)
+}
+
+// Increase of a counter, or its value if it was reset (eg. the container restarted)
+func counterDelta(prev, now int64) int64 {
+	if now < prev {
+		return now
+	}
+	return now - prev
+}
+
+// Forget the baseline of a stopped container, so its next start begins a new one
+func (s *activityTracker) reset(name string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.counters, name)
+}
+
+// The last n samples of a container (all, if n <= 0)
+func (s *activityTracker) history(name string, n int) []ActivitySample {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	samples := s.samples[name]
+	if n > 0 && len(samples) > n {
+		samples = samples[len(samples)-n:]
+	}
+	return append([]ActivitySample(nil), samples...)
+}
+
+func (s *activityTracker) all(n int) map[string][]ActivitySample {
+	s.mux.Lock()
+	names := make([]string, 0, len(s.samples))
+	for name := range s.samples {
+		names = append(names, name)
+	}
+	s.mux.Unlock()
+
+	ret := make(map[string][]ActivitySample, len(names))
+	for _, name := range names {
+		ret[name] = s.history(name, n)
+	}
+	return ret
+}
+
+// Persist the history, if it has a file and wasn't saved recently (or force)
+func (s *activityTracker) save(force bool) error {
+	if s.path == "" || !s.enabled() {
+		return nil
+	}
+
+	s.saveMux.Lock()
+	defer s.saveMux.Unlock()
+	s.mux.Lock()
+	if !force && time.Since(s.saved) < activitySaveInterval {
+		s.mux.Unlock()
+		return nil
+	}
+	s.saved = time.Now()
+	data, err := json.Marshal(s.samples)
+	s.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Summary of a container's recent activity, to help tune its stopdelay
+type ActivitySummary struct {
+	Samples     int
+	Since       time.Time
+	ActivePct   float64       // percent of samples with any traffic
+	LongestIdle time.Duration // longest run without traffic, between two active samples
+	AvgCPU      float64
+	PeakMemory  int64
+	Rx, Tx      int64 // total over the history
+}
+
+func summarizeActivity(samples []ActivitySample) (ret ActivitySummary) {
+	ret.Samples = len(samples)
+	if ret.Samples == 0 {
+		return
+	}
+	ret.Since = samples[0].Time
+
+	var active int
+	var cpu float64
+	var lastActive time.Time
+	for _, sample := range samples {
+		ret.Rx += sample.Rx
+		ret.Tx += sample.Tx
+		cpu += sample.CPU
+		if sample.Memory > ret.PeakMemory {
+			ret.PeakMemory = sample.Memory
+		}
+		if sample.Rx > 0 || sample.Tx > 0 {
+			active++
+			if !lastActive.IsZero() {
+				if gap := sample.Time.Sub(lastActive); gap > ret.LongestIdle {
+					ret.LongestIdle = gap
+				}
+			}
+			lastActive = sample.Time
+		}
+	}
+	ret.ActivePct = float64(active) * 100 / float64(ret.Samples)
+	ret.AvgCPU = cpu / float64(ret.Samples)
+	return
+}
+
+// Recent per-poll samples of a container (the last n, or all if n <= 0). Samples are
+// only taken by the leader, while the container is running
+func (s *Core) Activity(name string, n int) []ActivitySample {
+	return s.activity.history(name, n)
+}
+
+// Recent per-poll samples of all containers with a history
+func (s *Core) AllActivity(n int) map[string][]ActivitySample {
+	return s.activity.all(n)
+}
+
+// Summaries of each container's activity history, by name
+func (s *Core) ActivitySummaries() map[string]ActivitySummary {
+	all := s.activity.all(0)
+	ret := make(map[string]ActivitySummary, len(all))
+	for name, samples := range all {
+		ret[name] = summarizeActivity(samples)
+	}
+	return ret
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func activityStats(rx, tx uint64, cpu, system uint64, mem uint64) *types.StatsJSON {
+	var stats types.StatsJSON
+	stats.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: rx, TxBytes: tx}}
+	stats.CPUStats.CPUUsage.TotalUsage = cpu
+	stats.CPUStats.SystemUsage = system
+	stats.CPUStats.OnlineCPUs = 2
+	stats.MemoryStats.Usage = mem
+	return &stats
+}
+
+func TestActivityDeltas(t *testing.T) {
+	tracker, err := newActivityTracker(2, "")
+	assert.NoError(t, err)
+
+	tracker.observe("web", "a", activityStats(100, 50, 0, 0, 10))
+	assert.Empty(t, tracker.history("web", 0), "first stats are the baseline")
+
+	tracker.observe("web", "a", activityStats(150, 50, 100, 1000, 20))
+	samples := tracker.history("web", 0)
+	if assert.Len(t, samples, 1) {
+		assert.Equal(t, int64(50), samples[0].Rx)
+		assert.Equal(t, int64(0), samples[0].Tx)
+		assert.InDelta(t, 20.0, samples[0].CPU, 0.001)
+		assert.Equal(t, int64(20), samples[0].Memory)
+	}
+
+	// Counters reset on restart
+	tracker.observe("web", "a", activityStats(30, 5, 200, 2000, 20))
+	tracker.observe("web", "a", activityStats(40, 5, 300, 3000, 20))
+	samples = tracker.history("web", 0)
+	assert.Len(t, samples, 2, "bounded")
+	assert.Equal(t, int64(10), samples[1].Rx)
+	assert.Len(t, tracker.history("web", 1), 1)
+
+	// A re-created container starts a new baseline
+	tracker.observe("web", "b", activityStats(1000, 1000, 0, 0, 0))
+	assert.Len(t, tracker.history("web", 0), 2)
+}
+
+func TestActivityPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "activity.json")
+	tracker, _ := newActivityTracker(5, path)
+	tracker.observe("web", "a", activityStats(0, 0, 0, 0, 0))
+	tracker.observe("web", "a", activityStats(10, 10, 0, 0, 0))
+	assert.NoError(t, tracker.save(true))
+
+	loaded, err := newActivityTracker(5, path)
+	assert.NoError(t, err)
+	assert.Len(t, loaded.history("web", 0), 1)
+}
+
+func TestSummarizeActivity(t *testing.T) {
+	now := time.Now()
+	summary := summarizeActivity([]ActivitySample{
+		{Time: now, Rx: 10, CPU: 10, Memory: 5},
+		{Time: now.Add(10 * time.Second), Memory: 8},
+		{Time: now.Add(20 * time.Second), Memory: 6},
+		{Time: now.Add(30 * time.Second), Tx: 5, CPU: 30},
+	})
+	assert.Equal(t, 4, summary.Samples)
+	assert.Equal(t, 50.0, summary.ActivePct)
+	assert.Equal(t, 30*time.Second, summary.LongestIdle)
+	assert.Equal(t, 10.0, summary.AvgCPU)
+	assert.Equal(t, int64(8), summary.PeakMemory)
+	assert.Equal(t, int64(10), summary.Rx)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"traefik-lazyload/pkg/logging"
+
+	"github.com/sirupsen/logrus"
+)
+
+// How the adaptive stop delay reacts to a container being woken after an idle stop
+const (
+	adaptiveGrowth  = 1.5 // woken again within the delay: it was stopped too soon
+	adaptiveShrink  = 0.75
+	adaptiveIdleGap = 4 // woken after more than this many delays: it sat idle for long
+)
+
+// Adaptive stop delay of a container
+type AdaptiveDelay struct {
+	Container   string
+	Delay       time.Duration // effective
+	Base        time.Duration // from the stopdelay label or config
+	Adjustments int
+	LastReason  string
+	LastChange  time.Time
+}
+
+// Learns the stop delay of each container (by name) from how soon it is woken after
+// an idle stop, within [min, max]
+type adaptiveTracker struct {
+	min, max time.Duration
+
+	mux     sync.Mutex
+	delays  map[string]*AdaptiveDelay
+	stopped map[string]time.Time // name -> when it was last stopped for being idle
+}
+
+func newAdaptiveTracker(min, max time.Duration) *adaptiveTracker {
+	if max > 0 && max < min {
+		max = min
+	}
+	return &adaptiveTracker{
+		min:     min,
+		max:     max,
+		delays:  make(map[string]*AdaptiveDelay),
+		stopped: make(map[string]time.Time),
+	}
+}
+
+func (s *adaptiveTracker) clamp(delay time.Duration) time.Duration {
+	if delay < s.min {
+		delay = s.min
+	}
+	if s.max > 0 && delay > s.max {
+		delay = s.max
+	}
+	return delay
+}
+
+// Expects s.mux to be held
+func (s *adaptiveTracker) delayLocked(name string, base time.Duration) *AdaptiveDelay {
+	ret, ok := s.delays[name]
+	if !ok {
+		ret = &AdaptiveDelay{Container: name, Delay: s.clamp(base), Base: base}
+		s.delays[name] = ret
+	}
+	return ret
+}
+
+// Effective stop delay of a container
+func (s *adaptiveTracker) delay(name string, base time.Duration) time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.delayLocked(name, base).Delay
+}
+
+// Record that a container was stopped for being idle
+func (s *adaptiveTracker) idleStopped(name string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stopped[name] = time.Now()
+}
+
+// Adjust a container's delay when it is woken, by how long it was stopped: soon after
+// an idle stop grows it, after a long stretch shrinks it
+func (s *adaptiveTracker) woken(name string, base time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	stoppedAt, ok := s.stopped[name]
+	if !ok {
+		return // wasn't stopped for being idle
+	}
+	delete(s.stopped, name)
+
+	state := s.delayLocked(name, base)
+	gap := time.Since(stoppedAt)
+	prev := state.Delay
+	switch {
+	case gap < state.Delay:
+		state.Delay = s.clamp(time.Duration(float64(state.Delay) * adaptiveGrowth))
+		state.LastReason = "woken " + gap.Round(time.Second).String() + " after stopping"
+	case gap > adaptiveIdleGap*state.Delay:
+		state.Delay = s.clamp(time.Duration(float64(state.Delay) * adaptiveShrink))
+		state.LastReason = "idle for " + gap.Round(time.Second).String()
+	default:
+		return
+	}
+	if state.Delay != prev {
+		state.Adjustments++
+		state.LastChange = time.Now()
+		logrus.WithField(logging.FieldContainerName, name).Infof("Adaptive stop delay: %s -> %s (%s)", prev, state.Delay, state.LastReason)
+	}
+}
+
+// Adaptive stop delays learned so far, by container name
+func (s *adaptiveTracker) all() []AdaptiveDelay {
+	s.mux.Lock()
+	ret := make([]AdaptiveDelay, 0, len(s.delays))
+	for _, state := range s.delays {
+		ret = append(ret, *state)
+	}
+	s.mux.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Container < ret[j].Container
+	})
+	return ret
+}
+
+// Effective stop delay of a container: learned if it has `adaptivedelay`, otherwise its `stopdelay`
+func (s *Core) StopDelay(cts *ContainerState) time.Duration {
+	if !cts.adaptiveDelay {
+		return cts.stopDelay
+	}
+	return s.adaptive.delay(cts.cname, cts.stopDelay)
+}
+
+// When the container will be stopped, if it has no more network activity
+func (s *Core) IdleStopAt(cts *ContainerState) time.Time {
+	return cts.LastActive().Add(s.StopDelay(cts))
+}
+
+// Adaptive stop delays of containers that have them, for the status page
+func (s *Core) AdaptiveDelays() []AdaptiveDelay {
+	return s.adaptive.all()
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveDelay(t *testing.T) {
+	tracker := newAdaptiveTracker(time.Minute, 10*time.Minute)
+	assert.Equal(t, time.Minute, tracker.delay("web", 30*time.Second), "clamped to min")
+	assert.Equal(t, 5*time.Minute, tracker.delay("api", 5*time.Minute))
+
+	// Woken without an idle stop first: unchanged
+	tracker.woken("api", 5*time.Minute)
+	assert.Equal(t, 5*time.Minute, tracker.delay("api", 5*time.Minute))
+
+	// Woken soon after an idle stop: grows, up to max
+	for i := 0; i < 3; i++ {
+		tracker.idleStopped("api")
+		tracker.woken("api", 5*time.Minute)
+	}
+	assert.Equal(t, 10*time.Minute, tracker.delay("api", 5*time.Minute))
+
+	// Stopped for long: shrinks
+	tracker.stopped["api"] = time.Now().Add(-time.Hour)
+	tracker.woken("api", 5*time.Minute)
+	assert.Equal(t, 7*time.Minute+30*time.Second, tracker.delay("api", 5*time.Minute))
+
+	all := tracker.all()
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "api", all[0].Container)
+		assert.Equal(t, 3, all[0].Adjustments)
+		assert.Contains(t, all[0].LastReason, "idle for")
+	}
+}
+
+func TestAdaptiveStopDelayLabel(t *testing.T) {
+	host := newMockHost()
+	core := newTestCore(t, host)
+	host.add("w", "web", "running", lazyLabels("web.example.com", "lazyloader.stopdelay", "2m", "lazyloader.adaptivedelay", "true"))
+	host.add("a", "api", "running", lazyLabels("api.example.com", "lazyloader.stopdelay", "2m"))
+	core.Poll()
+
+	for _, cts := range core.ActiveContainers() {
+		assert.Equal(t, 2*time.Minute, core.StopDelay(cts))
+		if cts.ContainerName() == "web" {
+			core.adaptive.idleStopped("web")
+			core.adaptive.woken("web", cts.stopDelay)
+			assert.Equal(t, 3*time.Minute, core.StopDelay(cts))
+		}
+	}
+	assert.Len(t, core.AdaptiveDelays(), 1)
+}
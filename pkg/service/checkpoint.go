@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Experimental: checkpoint (CRIU) stop method; requires docker's experimental mode
+const StopMethodCheckpoint = "checkpoint"
+
+const checkpointPrefix = "lazyload-"
+
+// Information about the latest checkpoint of a container
+type CheckpointInfo struct {
+	Container string
+	Name      string
+	Created   time.Time
+	Size      int64 // -1 if unknown
+}
+
+func (s *CheckpointInfo) Age() time.Duration {
+	return time.Since(s.Created).Round(time.Second)
+}
+
+// Checkpoint directory for a container; empty for docker's default
+func checkpointDir(containerName string) string {
+	if config.Model.CheckpointDir == "" {
+		return ""
+	}
+	return filepath.Join(config.Model.CheckpointDir, containerName)
+}
+
+// Checkpoint the container and exit it. On failure, fall back to a regular stop
+func (s *Core) checkpointContainer(ctx context.Context, cid string, settings *stopSettings) error {
+	info, err := s.client.ContainerInspect(ctx, cid)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(info.Name, "/")
+	dir := checkpointDir(name)
+
+	checkpointID := fmt.Sprintf("%s%d", checkpointPrefix, time.Now().Unix())
+	err = s.client.CheckpointCreate(ctx, cid, types.CheckpointCreateOptions{
+		CheckpointID:  checkpointID,
+		CheckpointDir: dir,
+		Exit:          true,
+	})
+
+	if err != nil {
+		containers.Logger(cid, name).Warnf("Unable to checkpoint, stopping instead: %v", err)
+		s.pruneCheckpoints(ctx, cid, dir, "") // don't restore a stale checkpoint later
+		return s.client.ContainerStop(ctx, cid, settings.stopOptions())
+	}
+
+	containers.Logger(cid, name).Infof("Checkpointed as %s", checkpointID)
+	s.pruneCheckpoints(ctx, cid, dir, checkpointID)
+	return nil
+}
+
+// Restore the container from its latest checkpoint. Falls back to a cold start on failure
+func (s *Core) restoreContainer(ctx context.Context, ct *containers.Wrapper) error {
+	dir := checkpointDir(ct.Name())
+	if latest := s.latestCheckpoint(ctx, ct.ID, dir); latest != "" {
+		err := s.client.ContainerStart(ctx, ct.ID, types.ContainerStartOptions{
+			CheckpointID:  latest,
+			CheckpointDir: dir,
+		})
+		if err == nil {
+			ct.Log().Infof("Restored from checkpoint %s", latest)
+			return nil
+		}
+		ct.Log().Warnf("Unable to restore from checkpoint %s, cold starting: %v", latest, err)
+		s.pruneCheckpoints(ctx, ct.ID, dir, "")
+	}
+	return s.client.ContainerStart(ctx, ct.ID, types.ContainerStartOptions{})
+}
+
+// Returns our checkpoints of a container, newest first
+func (s *Core) listCheckpoints(ctx context.Context, cid, dir string) []string {
+	checkpoints, err := s.client.CheckpointList(ctx, cid, types.CheckpointListOptions{CheckpointDir: dir})
+	if err != nil {
+		containers.Logger(cid, "").Debugf("Unable to list checkpoints: %v", err)
+		return nil
+	}
+
+	var names []string
+	for _, cp := range checkpoints {
+		if strings.HasPrefix(cp.Name, checkpointPrefix) {
+			names = append(names, cp.Name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return checkpointTime(names[i]).After(checkpointTime(names[j]))
+	})
+	return names
+}
+
+func (s *Core) latestCheckpoint(ctx context.Context, cid, dir string) string {
+	if names := s.listCheckpoints(ctx, cid, dir); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// Delete all of our checkpoints except keep
+func (s *Core) pruneCheckpoints(ctx context.Context, cid, dir, keep string) {
+	for _, name := range s.listCheckpoints(ctx, cid, dir) {
+		if name == keep {
+			continue
+		}
+		err := s.client.CheckpointDelete(ctx, cid, types.CheckpointDeleteOptions{
+			CheckpointID:  name,
+			CheckpointDir: dir,
+		})
+		if err != nil {
+			containers.Logger(cid, "").Warnf("Unable to delete checkpoint %s: %v", name, err)
+		}
+	}
+}
+
+// Latest checkpoint of every qualifying container using the checkpoint stop method
+func (s *Core) Checkpoints(ctx context.Context) []CheckpointInfo {
+	cts, err := s.discovery.FindAllLazyload(ctx, true)
+	if err != nil {
+		logrus.Warnf("Unable to list containers: %v", err)
+		return nil
+	}
+
+	var ret []CheckpointInfo
+	for _, ct := range cts {
+		if method, _ := ct.Config("stopmethod"); !strings.EqualFold(method, StopMethodCheckpoint) {
+			continue
+		}
+
+		dir := checkpointDir(ct.Name())
+		if latest := s.latestCheckpoint(ctx, ct.ID, dir); latest != "" {
+			info := CheckpointInfo{
+				Container: ct.NameID(),
+				Name:      latest,
+				Created:   checkpointTime(latest),
+				Size:      -1,
+			}
+			if dir != "" {
+				info.Size = dirSize(filepath.Join(dir, latest))
+			}
+			ret = append(ret, info)
+		}
+	}
+	return ret
+}
+
+func checkpointTime(name string) time.Time {
+	unix, _ := strconv.ParseInt(strings.TrimPrefix(name, checkpointPrefix), 10, 64)
+	return time.Unix(unix, 0)
+}
+
+// Size of all files in a directory, or -1 if it can't be read
+func dirSize(path string) (size int64) {
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func checkpointLabels(host string) map[string]string {
+	return lazyLabels(host, "lazyloader.stopmethod", "checkpoint")
+}
+
+func TestCheckpointAndRestore(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "running", checkpointLabels("a.example.com"))
+	host.addCheckpoints("a", "lazyload-100", "manual")
+	core := newTestCore(t, host)
+	ctx := context.Background()
+	core.Poll()
+
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	assert.Equal(t, 1, host.opCount("checkpoint", "a"))
+	assert.Equal(t, 0, host.stopCount("a"))
+
+	// The old checkpoint is pruned, but not ones we didn't make
+	checkpoints := host.checkpointsOf("a")
+	if assert.Len(t, checkpoints, 2) {
+		assert.Equal(t, "manual", checkpoints[0])
+		assert.True(t, strings.HasPrefix(checkpoints[1], checkpointPrefix))
+	}
+	latest := checkpoints[len(checkpoints)-1]
+
+	info := core.Checkpoints(ctx)
+	if assert.Len(t, info, 1) {
+		assert.Equal(t, latest, info[0].Name)
+		assert.Equal(t, int64(-1), info[0].Size)
+	}
+
+	startStopped(t, core, "app")
+	assert.Equal(t, 1, host.opCount("restore", "a:"+latest))
+	assert.Equal(t, 1, host.startCount("a"))
+}
+
+func TestCheckpointFailureStops(t *testing.T) {
+	host := newMockHost()
+	host.checkpointErr = errors.New("criu not found")
+	host.add("a", "app", "running", checkpointLabels("a.example.com"))
+	host.addCheckpoints("a", "lazyload-100")
+	core := newTestCore(t, host)
+	ctx := context.Background()
+	core.Poll()
+
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	assert.Equal(t, 1, host.opCount("checkpoint", "a"))
+	assert.Equal(t, 1, host.stopCount("a"))
+	assert.Empty(t, host.checkpointsOf("a"), "a stale checkpoint shouldn't be restored later")
+
+	// Nothing to restore, so it's cold started
+	startStopped(t, core, "app")
+	assert.Equal(t, 0, host.opCount("restore", "a:lazyload-100"))
+	assert.Equal(t, 1, host.startCount("a"))
+}
+
+func TestRestoreFailureColdStarts(t *testing.T) {
+	host := newMockHost()
+	host.restoreErr = errors.New("restore failed")
+	host.add("a", "app", "exited", checkpointLabels("a.example.com"))
+	host.addCheckpoints("a", "lazyload-100", "lazyload-200")
+	core := newTestCore(t, host)
+
+	startStopped(t, core, "app")
+	assert.Equal(t, 1, host.opCount("restore", "a:lazyload-200"))
+	assert.Equal(t, 0, host.opCount("restore", "a:lazyload-100"))
+	assert.Equal(t, 1, host.startCount("a"))
+	assert.Empty(t, host.checkpointsOf("a"))
+}
@@ -1,74 +1,374 @@
 package service
 
 import (
+	"fmt"
+	"sync"
 	"time"
 	"traefik-lazyload/pkg/config"
 	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/logging"
+
+	"github.com/sirupsen/logrus"
 )
 
 type containerSettings struct {
 	stopDelay     time.Duration
+	adaptiveDelay bool // learn the stop delay, starting from stopDelay
 	waitForCode   int
 	waitForPath   string
 	waitForMethod string
 	needs         []string
+	tcpPort       int
+	splash        string
+	responseStyle string // force html, json or text; empty to negotiate
+	responseCode  int
+	retryAfter    time.Duration
+	group         string                  // limited by config grouplimits
+	pinned        bool                    // never stopped automatically (idle, or to make room)
+	startsPerMin  int                     // 0 is unlimited
+	hooks         map[string]hookSettings // by lifecycle point
+	drain         drainSettings
+	stopSettings
 }
 
 type ContainerState struct {
-	name string
+	cname string // docker container name
 	containerSettings
+
+	mux                sync.Mutex // guards everything below
+	id                 string
+	name               string // name + id
+	labels             map[string]string
 	lastRecv, lastSend int64 // Last network traffic, used to see if idle
 	lastActivity       time.Time
 	started            time.Time
-	pinned             bool // Don't remove, even if not started
+	requested          time.Time // when the current start was asked for, before any queueing
+	phase              Phase
+	transitions        []Transition
+	restartQueued      bool      // Requested while stopping; start again once stopped
+	drainSince         time.Time // when it started waiting for connections to close, if idle
+	requestID          string    // of the request that last started it, if any
 }
 
-func newStateFromContainer(ct *containers.Wrapper) *ContainerState {
+func newStateFromContainer(ct *containers.Wrapper, phase Phase) *ContainerState {
+	now := time.Now()
 	return &ContainerState{
+		id:                ct.ID,
 		name:              ct.NameID(),
+		cname:             ct.Name(),
+		labels:            ct.Labels,
 		containerSettings: extractContainerLabels(ct),
-		lastActivity:      time.Now(),
-		started:           time.Now(),
+		lastActivity:      now,
+		started:           now,
+		phase:             phase,
+		transitions:       []Transition{{phase, now}},
 	}
 }
 
 func extractContainerLabels(ct *containers.Wrapper) (target containerSettings) {
 	target.stopDelay, _ = ct.ConfigDuration("stopdelay", config.Model.StopDelay)
+	target.adaptiveDelay, _ = ct.ConfigBool("adaptivedelay", config.Model.AdaptiveDelay)
 	target.waitForCode, _ = ct.ConfigInt("waitforcode", 200)
 	target.waitForPath, _ = ct.ConfigOrDefault("waitforpath", "/")
 	target.waitForMethod, _ = ct.ConfigOrDefault("waitformethod", "HEAD")
 	target.needs, _ = ct.ConfigCSV("needs", nil)
+	target.tcpPort, _ = ct.ConfigInt("tcp.port", ct.TraefikTCPPort())
+	target.splash, _ = ct.Config("splash")
+	target.responseStyle, _ = ct.Config("response")
+	target.responseCode, _ = ct.ConfigInt("response.code", 0)
+	target.retryAfter, _ = ct.ConfigDuration("retryafter", 5*time.Second)
+	target.group, _ = ct.Config("group")
+	target.pinned, _ = ct.ConfigBool("pin", false)
+	target.startsPerMin, _ = ct.ConfigInt("startsperminute", config.Model.StartsPerMinute)
+	target.hooks = extractHooks(ct)
+	target.drain = extractDrainSettings(ct)
+	target.stopSettings = extractStopSettings(ct)
+	return
+}
+
+// Move to a new phase, if valid from the current phase. Expects lock to be held
+func (s *ContainerState) transitionLocked(to Phase) error {
+	if !canTransition(s.phase, to) {
+		return fmt.Errorf("%w: %s -> %s (%s)", ErrInvalidTransition, s.phase, to, s.name)
+	}
+
+	now := time.Now()
+	s.phase = to
+	if len(s.transitions) >= transitionHistoryLen {
+		s.transitions = s.transitions[1:]
+	}
+	s.transitions = append(s.transitions, Transition{to, now})
+
+	switch to {
+	case PhaseStarting:
+		s.started = now
+	case PhaseRunning:
+		s.lastActivity = now // idle timer starts once running
+		s.drainSince = time.Time{}
+	}
+	return nil
+}
+
+func (s *ContainerState) transition(to Phase) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.transitionLocked(to)
+}
+
+// Ask for the container to be started. Returns true if the caller should start it;
+// if it is stopping, a restart is queued instead
+func (s *ContainerState) requestStart() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch s.phase {
+	case PhaseStopped, PhaseFailed:
+		return s.transitionLocked(PhaseStarting) == nil
+	case PhaseStopping:
+		s.restartQueued = true
+	}
+	return false
+}
+
+// Mark the container as stopping. Returns false if it isn't in a phase that can be
+// stopped (Starting and WaitingReady can only be stopped if forced)
+func (s *ContainerState) beginStop(force bool) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch s.phase {
+	case PhaseQueued:
+		s.transitionLocked(PhaseStopped) // never started; just drop out of the queue
+		return false
+	case PhaseStarting, PhaseWaitingReady:
+		if !force {
+			return false
+		}
+	case PhaseStopping, PhaseStopped:
+		return false
+	}
+	return s.transitionLocked(PhaseStopping) == nil
+}
+
+// Update the network activity of a running container from its counters. Returns whether
+// there was any, and if not, whether it's been idle for longer than stopDelay
+func (s *ContainerState) observeTraffic(rx, tx int64, stopDelay time.Duration) (active, idle bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.phase != PhaseRunning && s.phase != PhaseIdle {
+		return false, false // changed while getting stats
+	}
+
+	if rx > s.lastRecv || tx > s.lastSend {
+		s.lastRecv = rx
+		s.lastSend = tx
+		s.lastActivity = time.Now()
+		s.drainSince = time.Time{}
+		if s.phase == PhaseIdle {
+			s.transitionLocked(PhaseRunning)
+		}
+		return true, false
+	}
+
+	if s.phase == PhaseRunning {
+		s.transitionLocked(PhaseIdle)
+	}
+	return false, time.Now().After(s.lastActivity.Add(stopDelay))
+}
+
+// Mark an idle container as stopping. Returns false if it is no longer idle
+func (s *ContainerState) beginIdleStop() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.phase != PhaseIdle {
+		return false
+	}
+	return s.transitionLocked(PhaseStopping) == nil
+}
+
+// Start waiting for connections to close, if not already. Returns when it started
+func (s *ContainerState) beginDrain() (since time.Time, first bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.drainSince.IsZero() {
+		s.drainSince = time.Now()
+		first = true
+	}
+	return s.drainSince, first
+}
+
+// When the container started waiting for its connections to close before an idle stop
+// (zero if it isn't)
+func (s *ContainerState) DrainingSince() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.drainSince
+}
+
+// Container was found not running by polling. Returns true if it should be removed
+func (s *ContainerState) discoverStopped() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch s.phase {
+	case PhaseRunning, PhaseIdle, PhaseFailed:
+		return s.transitionLocked(PhaseStopped) == nil
+	}
+	return false
+}
+
+// Stop failed; the container is still running
+func (s *ContainerState) abortStop() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.restartQueued = false
+	s.transitionLocked(PhaseRunning)
+}
+
+// Stop succeeded. Returns true if a restart was requested while stopping
+func (s *ContainerState) completeStop() (restart bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	restart = s.restartQueued
+	s.restartQueued = false
+	s.transitionLocked(PhaseStopped)
 	return
 }
 
+func (s *ContainerState) Phase() Phase {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.phase
+}
+
+// When the current phase was entered
+func (s *ContainerState) PhaseSince() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.transitions[len(s.transitions)-1].Time
+}
+
+// Recent phase transitions, oldest first
+func (s *ContainerState) Transitions() []Transition {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]Transition(nil), s.transitions...)
+}
+
+// true if in a phase that shouldn't be interrupted by polling
+func (s *ContainerState) isBusy() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch s.phase {
+	case PhaseStarting, PhaseWaitingReady, PhaseStopping:
+		return true
+	}
+	return false
+}
+
+// Wrapper to start the container again, from what we know of it
+func (s *ContainerState) wrapper() *containers.Wrapper {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := &containers.Wrapper{}
+	ret.ID = s.id
+	ret.Names = []string{"/" + s.cname}
+	ret.Labels = s.labels
+	return ret
+}
+
+func (s *ContainerState) ID() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.id
+}
+
 func (s *ContainerState) Name() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.name
 }
 
+// Logger with the container's ID and name, and the request that started it (if any)
+func (s *ContainerState) log() *logrus.Entry {
+	s.mux.Lock()
+	id, requestID := s.id, s.requestID
+	s.mux.Unlock()
+
+	ret := containers.Logger(id, s.cname)
+	if requestID != "" {
+		ret = ret.WithField(logging.FieldRequestID, requestID)
+	}
+	return ret
+}
+
+// Record the request a start is for, to log with what follows
+func (s *ContainerState) setRequestID(id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requestID = id
+}
+
+// Docker container name, which is stable even if the container is re-created
+func (s *ContainerState) ContainerName() string {
+	return s.cname
+}
+
 func (s *ContainerState) LastActive() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.lastActivity
 }
 
 func (s *ContainerState) LastActiveAge() string { // FIXME: Return duration (update UI)
-	return time.Since(s.lastActivity).Round(time.Second).String()
+	return time.Since(s.LastActive()).Round(time.Second).String()
 }
 
 func (s *ContainerState) Rx() int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.lastRecv
 }
 
 func (s *ContainerState) Tx() int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.lastSend
 }
 
 func (s *ContainerState) Started() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.started
 }
 
+func (s *ContainerState) markRequested() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requested = time.Now()
+}
+
+// When the current start was asked for, or when it started if it wasn't asked for
+func (s *ContainerState) requestedAt() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.requested.IsZero() {
+		return s.started
+	}
+	return s.requested
+}
+
 func (s *containerSettings) StopDelay() string { // FIXME: Return duration (update UI)
 	return s.stopDelay.String()
 }
 
+// True if the stop delay is learned. See Core.StopDelay for the effective delay
+func (s *containerSettings) AdaptiveDelay() bool {
+	return s.adaptiveDelay
+}
+
 func (s *ContainerState) WaitForCode() int {
 	return s.waitForCode
 }
@@ -80,3 +380,33 @@ func (s *ContainerState) WaitForPath() string {
 func (s *ContainerState) WaitForMethod() string {
 	return s.waitForMethod
 }
+
+func (s *ContainerState) Splash() string {
+	return s.splash
+}
+
+func (s *ContainerState) ResponseStyle() string {
+	return s.responseStyle
+}
+
+func (s *ContainerState) ResponseCode() int {
+	return s.responseCode
+}
+
+func (s *ContainerState) RetryAfter() time.Duration {
+	return s.retryAfter
+}
+
+// Dependencies, by what their providers provide
+func (s *ContainerState) Needs() []string {
+	return s.needs
+}
+
+func (s *ContainerState) Group() string {
+	return s.group
+}
+
+// Pinned by label. See Core.IsPinned for pins set at runtime
+func (s *ContainerState) Pinned() bool {
+	return s.pinned
+}
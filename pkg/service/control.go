@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"traefik-lazyload/pkg/containers"
+)
+
+// Start a lazyload container by name, like a request for its host would
+func (s *Core) StartByName(ctx context.Context, name string) (*ContainerState, error) {
+	cts, err := s.discovery.FindAllLazyload(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cts {
+		if cts[i].Name() == name {
+			s.inspectMemoryLimit(ctx, cts[i].ID, name)
+			return s.startContainer(ctx, &cts[i], startRequested)
+		}
+	}
+
+	if spec := s.recreatable(s.specs.Get(name)); spec != nil {
+		return s.recreateHost(ctx, name, spec)
+	}
+	return nil, containers.ErrNotFound
+}
+
+// Stop a managed container by name, even if it isn't idle (or is still starting)
+func (s *Core) StopByName(ctx context.Context, name string) error {
+	s.mux.Lock()
+	cts := s.stateByNameLocked(name)
+	s.mux.Unlock()
+
+	if cts == nil {
+		return ErrNotRunning
+	}
+	if cts.Phase() == PhaseQueued {
+		cts.beginStop(true) // drops it from the queue
+		return nil
+	}
+	if !cts.beginStop(true) {
+		return ErrNotRunning
+	}
+
+	cts.log().Info("Stopping container, as requested")
+	return s.stopContainerAndDependencies(ctx, cts)
+}
+
+// Pin a container by name, so it is never stopped automatically (idle, or to make
+// room). This is in addition to the `pin` label, and survives restarts
+func (s *Core) SetPinned(name string, pinned bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if pinned {
+		s.pins[name] = true
+	} else {
+		delete(s.pins, name)
+	}
+}
+
+// True if a container is pinned, by label or by SetPinned
+func (s *Core) IsPinned(cts *ContainerState) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.pinnedLocked(cts)
+}
+
+// True if a container was pinned with SetPinned (whether or not it is running)
+func (s *Core) IsPinnedByName(name string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.pins[name]
+}
+
+func (s *Core) pinnedLocked(cts *ContainerState) bool {
+	return cts.pinned || s.pins[cts.cname]
+}
+
+// The managed state of a container by name, preferring one that isn't stopped.
+// Expects s.mux to be held
+func (s *Core) stateByNameLocked(name string) (ret *ContainerState) {
+	for _, cts := range s.allStatesLocked() {
+		if cts.cname != name {
+			continue
+		}
+		if phase := cts.Phase(); phase != PhaseStopped && phase != PhaseFailed {
+			return cts
+		}
+		ret = cts
+	}
+	return
+}
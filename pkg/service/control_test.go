@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartStopByName(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com"))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+
+	_, err := core.StartByName(ctx, "missing")
+	assert.ErrorIs(t, err, containers.ErrNotFound)
+
+	cts, err := core.StartByName(ctx, "app")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	assert.Equal(t, PhaseStopped, cts.Phase())
+	assert.Equal(t, 1, host.stopCount("a"))
+	assert.ErrorIs(t, core.StopByName(ctx, "app"), ErrNotRunning)
+}
+
+func TestPinnedIsNotStoppedWhenIdle(t *testing.T) {
+	host := newMockHost()
+	core := newTestCore(t, host)
+	core.SetPinned("app", true)
+	host.add("a", "app", "running", lazyLabels("a.example.com", "lazyloader.stopdelay", "0s"))
+	core.Poll()
+	core.Poll()
+	assert.Equal(t, 0, host.stopCount("a"))
+
+	core.SetPinned("app", false)
+	core.Poll()
+	assert.Equal(t, 1, host.stopCount("a"))
+}
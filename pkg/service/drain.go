@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+)
+
+// Drain check that counts the container's established inbound TCP connections
+const DrainConnections = "connections"
+
+// TCP states in /proc/net/tcp
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// How an idle container is drained before it is stopped
+type drainSettings struct {
+	check string        // DrainConnections, or an http(s) URL to POST to
+	max   time.Duration // stop anyway after draining this long
+}
+
+func extractDrainSettings(ct *containers.Wrapper) (ret drainSettings) {
+	ret.check, _ = ct.Config("drain")
+	ret.max, _ = ct.ConfigDuration("drain.max", config.Model.DrainMax)
+	return
+}
+
+func (s *drainSettings) enabled() bool {
+	return s.check != "" && s.max > 0
+}
+
+// Whether an idle container can be stopped: it has no drain check, the check finds no
+// open connections, or it has been draining for longer than drain.max. Errors checking
+// don't hold up the stop
+func (s *Core) drained(ctx context.Context, cts *ContainerState) bool {
+	if !cts.drain.enabled() {
+		return true
+	}
+
+	since, first := cts.beginDrain()
+	if time.Since(since) >= cts.drain.max {
+		s.emit(cts, EventWarning, "Stopping with connections still open, after draining for %s", cts.drain.max)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Model.Timeout)
+	defer cancel()
+	open, err := s.openConnections(ctx, cts)
+	if err != nil {
+		cts.log().Warnf("Unable to check connections, stopping anyway: %v", err)
+		return true
+	}
+	if open == 0 {
+		return true
+	}
+
+	if first {
+		s.emit(cts, EventDraining, "Waiting for connections to close before stopping (up to %s)", cts.drain.max)
+	}
+	cts.log().Debugf("Postponing stop, %d connection(s) open", open)
+	return false
+}
+
+// Number of connections the container still has open, by its drain check
+func (s *Core) openConnections(ctx context.Context, cts *ContainerState) (int, error) {
+	if cts.drain.check != DrainConnections {
+		return postDrain(ctx, cts.drain.check, cts.cname)
+	}
+
+	output, err := s.execCommand(ctx, cts.ID(), []string{"cat", "/proc/net/tcp", "/proc/net/tcp6"}, nil)
+	if !strings.Contains(output, "local_address") {
+		// cat fails if either is missing (eg. without ipv6), so only fail if neither was read
+		if err == nil {
+			err = errors.New("unable to read /proc/net/tcp")
+		}
+		return 0, err
+	}
+	return countInboundConnections(output), nil
+}
+
+// POST to a container's drain endpoint, which answers 503 while connections remain and
+// 2xx once it's drained
+func postDrain(ctx context.Context, url, name string) (int, error) {
+	body := []byte(fmt.Sprintf(`{"container":%q}`, name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return 1, nil
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("drain endpoint returned %s", resp.Status)
+}
+
+// Count established TCP connections to a listening port, from /proc/net/tcp{,6}. Outgoing
+// connections (eg. a pool to a database) don't keep a container from stopping
+func countInboundConnections(procNetTCP string) int {
+	listening := make(map[string]bool)
+	var established []string
+	for _, line := range strings.Split(procNetTCP, "\n") {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		_, port, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue // header
+		}
+		switch fields[3] {
+		case tcpListen:
+			listening[port] = true
+		case tcpEstablished:
+			established = append(established, port)
+		}
+	}
+
+	var ret int
+	for _, port := range established {
+		if listening[port] {
+			ret++
+		}
+	}
+	return ret
+}
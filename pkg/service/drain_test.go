@@ -0,0 +1,82 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1000 1 0000000000000000 100 0 0 10 0
+   1: 0200000A:0050 0300000A:C350 01 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 20 4 30 10 -1
+   2: 0200000A:D431 0400000A:1538 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+`
+
+func TestCountInboundConnections(t *testing.T) {
+	// One to the listening port 80; the other is out to a database
+	assert.Equal(t, 1, countInboundConnections(procNetTCP))
+	assert.Equal(t, 0, countInboundConnections(""))
+}
+
+func TestDrainPostponesIdleStop(t *testing.T) {
+	host := newMockHost()
+	core := newTestCore(t, host)
+	host.execOutput = procNetTCP
+	host.add("a", "app", "running", lazyLabels("a.example.com",
+		"lazyloader.stopdelay", "0s",
+		"lazyloader.drain", DrainConnections))
+
+	core.Poll()
+	assert.Equal(t, 0, host.stopCount("a"))
+	cts := core.ActiveContainers()[0]
+	assert.False(t, cts.DrainingSince().IsZero())
+	assert.Equal(t, []string{"cat", "/proc/net/tcp", "/proc/net/tcp6"}, host.execCalls()[0].cmd)
+
+	host.mux.Lock()
+	host.execOutput = ""
+	host.mux.Unlock()
+	core.Poll()
+	assert.Equal(t, 1, host.stopCount("a")) // couldn't read connections; stopped anyway
+}
+
+func TestDrainMax(t *testing.T) {
+	host := newMockHost()
+	core := newTestCore(t, host)
+	host.execOutput = procNetTCP
+	host.add("a", "app", "running", lazyLabels("a.example.com",
+		"lazyloader.stopdelay", "0s",
+		"lazyloader.drain", DrainConnections,
+		"lazyloader.drain.max", "20ms"))
+
+	core.Poll()
+	assert.Equal(t, 0, host.stopCount("a"))
+	time.Sleep(30 * time.Millisecond)
+	core.Poll()
+	assert.Equal(t, 1, host.stopCount("a"))
+}
+
+func TestDrainEndpoint(t *testing.T) {
+	busy := int32(1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&busy) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	host := newMockHost()
+	core := newTestCore(t, host)
+	host.add("a", "app", "running", lazyLabels("a.example.com",
+		"lazyloader.stopdelay", "0s",
+		"lazyloader.drain", srv.URL))
+
+	core.Poll()
+	assert.Equal(t, 0, host.stopCount("a"))
+	atomic.StoreInt32(&busy, 0)
+	core.Poll()
+	assert.Equal(t, 1, host.stopCount("a"))
+}
@@ -3,5 +3,14 @@ package service
 import "errors"
 
 var (
-	ErrProviderNotFound = errors.New("provider not found")
+	ErrProviderNotFound  = errors.New("provider not found")
+	ErrNotRunning        = errors.New("container not running")
+	ErrNoAddress         = errors.New("no reachable address for container")
+	ErrUnhealthy         = errors.New("container is unhealthy")
+	ErrInvalidTransition = errors.New("invalid phase transition")
+	ErrNoPort            = errors.New("no tcp port configured for container")
+	ErrRateLimited       = errors.New("container started too often, try again later")
+	ErrOverBudget        = errors.New("container needs more memory than the whole budget")
+	ErrWakeAuthRequired  = errors.New("container requires wake authorization")
+	ErrNoRoom            = errors.New("no room under the running limits or memory budget")
 )
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventQueued    EventType = "queued"    // Waiting for room under the running limits
+	EventPulling   EventType = "pulling"   // Pulling a missing image
+	EventCreating  EventType = "creating"  // Re-creating a missing container
+	EventResolving EventType = "resolving" // Resolving dependencies
+	EventProvider  EventType = "provider"  // Starting a dependency provider
+	EventHook      EventType = "hook"      // Running a lifecycle hook
+	EventStarting  EventType = "starting"  // Starting the container
+	EventWaiting   EventType = "waiting"   // Waiting for the container to be healthy
+	EventReady     EventType = "ready"     // Container is ready to serve
+	EventWarning   EventType = "warning"   // Non-fatal problem while starting
+	EventFailed    EventType = "failed"    // Container failed to start
+	EventDraining  EventType = "draining"  // Waiting for connections to close before an idle stop
+	EventStopped   EventType = "stopped"   // Container was stopped
+	EventFlapping  EventType = "flapping"  // Container started too often (see flapstarts)
+	EventPoll      EventType = "poll"      // Containers were polled (not for any one container)
+)
+
+// Lifecycle event for a managed container
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      EventType `json:"type"`
+	ID        string    `json:"id"`        // Container ID, empty if not yet created
+	Name      string    `json:"name"`      // Container name, stable across re-creation
+	Container string    `json:"container"` // Human name + ID
+	Message   string    `json:"message"`
+}
+
+const (
+	eventHistoryLen = 32 // Events kept per container, for late subscribers
+	eventBufferLen  = 16 // Per-subscriber buffer before events are dropped
+)
+
+type eventSub struct {
+	name string // empty for all containers
+	ch   chan Event
+}
+
+// Fan-out of lifecycle events to subscribers, keeping the history of the latest
+// start of each container
+type eventBus struct {
+	mux     sync.Mutex
+	subs    map[*eventSub]struct{}
+	history map[string][]Event // name -> events since last start
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs:    make(map[*eventSub]struct{}),
+		history: make(map[string][]Event),
+	}
+}
+
+func (s *eventBus) publish(ev Event) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if ev.Name != "" {
+		history := s.history[ev.Name]
+		if len(history) >= eventHistoryLen {
+			history = history[1:]
+		}
+		s.history[ev.Name] = append(history, ev)
+	}
+
+	for sub := range s.subs {
+		if sub.name == "" || sub.name == ev.Name {
+			select {
+			case sub.ch <- ev:
+			default: // slow consumer, drop
+			}
+		}
+	}
+}
+
+// Clear the history of a container, at the beginning of a new start
+func (s *eventBus) reset(name string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.history, name)
+}
+
+// Subscribe to events for a container (or all, if name is empty). Returns past events
+// of the container's latest start, the live channel, and a func to unsubscribe
+func (s *eventBus) subscribe(name string) ([]Event, <-chan Event, func()) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	sub := &eventSub{name, make(chan Event, eventBufferLen)}
+	s.subs[sub] = struct{}{}
+
+	var history []Event
+	if name != "" {
+		history = append(history, s.history[name]...)
+	}
+
+	return history, sub.ch, func() {
+		s.mux.Lock()
+		delete(s.subs, sub)
+		s.mux.Unlock()
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBusHistory(t *testing.T) {
+	bus := newEventBus()
+	bus.publish(Event{Name: "a", Type: EventResolving})
+	bus.publish(Event{Name: "a", Type: EventStarting})
+	bus.publish(Event{Name: "b", Type: EventResolving})
+
+	history, ch, unsubscribe := bus.subscribe("a")
+	defer unsubscribe()
+	assert.Len(t, history, 2)
+
+	bus.publish(Event{Name: "b", Type: EventReady})
+	bus.publish(Event{Name: "a", Type: EventReady})
+	assert.Equal(t, EventReady, (<-ch).Type)
+	assert.Len(t, ch, 0)
+
+	// A new start resets the history
+	bus.reset("a")
+	bus.publish(Event{Name: "a", Type: EventResolving})
+	history, _, unsubscribe2 := bus.subscribe("a")
+	defer unsubscribe2()
+	assert.Len(t, history, 1)
+}
+
+func TestEventBusPoll(t *testing.T) {
+	bus := newEventBus()
+	_, all, unsubscribe := bus.subscribe("")
+	defer unsubscribe()
+	_, one, unsubscribe2 := bus.subscribe("a")
+	defer unsubscribe2()
+
+	bus.publish(Event{Type: EventPoll})
+	assert.Equal(t, EventPoll, (<-all).Type)
+	assert.Len(t, one, 0)
+	assert.Empty(t, bus.history)
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/logging"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Points in a container's lifecycle that can run a hook
+const (
+	HookPreStart  = "prestart"  // after dependencies are started; failing aborts the start
+	HookPostStart = "poststart" // once ready
+	HookPreStop   = "prestop"
+	HookPostStop  = "poststop"
+)
+
+var hookPoints = []string{HookPreStart, HookPostStart, HookPreStop, HookPostStop}
+
+// How much hook output is kept for the logs
+const hookOutputLimit = 64 * 1024
+
+// A command run at a point in the lifecycle: an http(s) URL to POST to, or a shell
+// command exec'd in the container (or in another, running, container)
+type hookSettings struct {
+	command   string
+	container string // name of the container to exec in, instead of this one
+	timeout   time.Duration
+}
+
+func (s *hookSettings) isHTTP() bool {
+	return strings.HasPrefix(s.command, "http://") || strings.HasPrefix(s.command, "https://")
+}
+
+func extractHooks(ct *containers.Wrapper) map[string]hookSettings {
+	var ret map[string]hookSettings
+	for _, point := range hookPoints {
+		command, ok := ct.Config("hook." + point)
+		if !ok || command == "" {
+			continue
+		}
+		hook := hookSettings{command: command}
+		hook.container, _ = ct.Config("hook." + point + ".container")
+		hook.timeout, _ = ct.ConfigDuration("hook."+point+".timeout", config.Model.Timeout)
+		if !hook.isHTTP() && hook.container == "" && (point == HookPreStart || point == HookPostStop) {
+			ct.Log().Warnf("Ignoring hook.%s, the container isn't running then; set hook.%s.container to exec in another", point, point)
+			continue
+		}
+		if ret == nil {
+			ret = make(map[string]hookSettings)
+		}
+		ret[point] = hook
+	}
+	return ret
+}
+
+// Run a container's hook for a point in its lifecycle, if it has one. The hook has its
+// own timeout, whatever is left of the caller's
+func (s *Core) runHook(ctx context.Context, cts *ContainerState, point string) error {
+	hook, ok := cts.hooks[point]
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(logging.WithRequestID(context.Background(), logging.RequestID(ctx)), hook.timeout)
+	defer cancel()
+
+	s.emit(cts, EventHook, "Running %s hook", point)
+	started := time.Now()
+	var output string
+	var err error
+	switch {
+	case hook.isHTTP():
+		output, err = postHook(ctx, hook.command, cts.cname, point)
+	default:
+		target := hook.container
+		if target == "" {
+			target = cts.ID()
+		}
+		output, err = s.execCommand(ctx, target, []string{"sh", "-c", hook.command},
+			[]string{"LAZYLOADER_CONTAINER=" + cts.cname, "LAZYLOADER_HOOK=" + point})
+	}
+
+	log := cts.log().WithField("hook", point)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		log.Info(scanner.Text())
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook: %w", point, err)
+	}
+	log.WithField(logging.FieldDuration, time.Since(started).Seconds()).Debug("Ran hook")
+	return nil
+}
+
+// Exec a command in a container, returning its combined output (up to hookOutputLimit)
+func (s *Core) execCommand(ctx context.Context, target string, cmd, env []string) (string, error) {
+	exec, err := s.client.ContainerExecCreate(ctx, target, types.ExecConfig{
+		Cmd:          cmd,
+		Env:          env,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Close()
+
+	// The attached stream doesn't follow ctx, so close it on timeout
+	copied := make(chan error, 1)
+	output := &limitedBuffer{limit: hookOutputLimit}
+	go func() {
+		_, err := stdcopy.StdCopy(output, output, resp.Reader)
+		copied <- err
+	}()
+	select {
+	case err = <-copied:
+	case <-ctx.Done():
+		resp.Close()
+		<-copied
+		return output.String(), ctx.Err()
+	}
+	if err != nil {
+		return output.String(), err
+	}
+
+	inspect, err := s.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return output.String(), err
+	}
+	if inspect.ExitCode != 0 {
+		return output.String(), fmt.Errorf("exited with %d", inspect.ExitCode)
+	}
+	return output.String(), nil
+}
+
+// POST a hook's container and point to a URL, returning the response body
+func postHook(ctx context.Context, url, name, point string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"container": name, "hook": point})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	output, _ := io.ReadAll(io.LimitReader(resp.Body, hookOutputLimit))
+	if resp.StatusCode >= 300 {
+		return string(output), fmt.Errorf("status %s", resp.Status)
+	}
+	return string(output), nil
+}
+
+// Keeps the first `limit` bytes written, discarding the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (s *limitedBuffer) Write(p []byte) (int, error) {
+	if room := s.limit - s.Len(); room > 0 {
+		if len(p) > room {
+			s.Buffer.Write(p[:room])
+		} else {
+			s.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
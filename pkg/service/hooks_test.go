@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostStartHookExecsInContainer(t *testing.T) {
+	host := newMockHost()
+	host.execOutput = "warmed up\n"
+	host.add("a", "app", "exited", lazyLabels("a.example.com", "lazyloader.hook.poststart", "./warm.sh"))
+	core := newTestCore(t, host)
+
+	cts, err := core.StartHost(context.Background(), "a.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return len(host.execCalls()) == 1 })
+
+	exec := host.execCalls()[0]
+	assert.Equal(t, "a", exec.container)
+	assert.Equal(t, []string{"sh", "-c", "./warm.sh"}, exec.cmd)
+	assert.Contains(t, exec.env, "LAZYLOADER_HOOK=poststart")
+	assert.Equal(t, PhaseRunning, cts.Phase())
+}
+
+func TestFailingPreStartHookAbortsStart(t *testing.T) {
+	host := newMockHost()
+	host.execExitCode = 1
+	host.add("db", "db", "running", nil)
+	host.add("a", "app", "exited", lazyLabels("a.example.com",
+		"lazyloader.hook.prestart", "migrate",
+		"lazyloader.hook.prestart.container", "db"))
+	core := newTestCore(t, host)
+
+	cts, err := core.StartHost(context.Background(), "a.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseFailed })
+
+	assert.Equal(t, 0, host.startCount("a"))
+	assert.Len(t, host.execCalls(), 1)
+	assert.Equal(t, "db", host.execCalls()[0].container)
+}
+
+func TestPreStartHookNeedsContainer(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com", "lazyloader.hook.prestart", "true"))
+	core := newTestCore(t, host)
+
+	// The incomplete hook is ignored, rather than failing every start
+	cts, err := core.StartHost(context.Background(), "a.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+	assert.Equal(t, 1, host.startCount("a"))
+	assert.Empty(t, host.execCalls())
+	assert.NotContains(t, cts.hooks, HookPreStart)
+}
+
+func TestStopHooksPostToURL(t *testing.T) {
+	var mux sync.Mutex
+	var calls []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		mux.Lock()
+		calls = append(calls, body)
+		mux.Unlock()
+	}))
+	defer srv.Close()
+
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com",
+		"lazyloader.hook.prestop", srv.URL,
+		"lazyloader.hook.poststop", srv.URL))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+
+	cts, err := core.StartByName(ctx, "app")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+	assert.NoError(t, core.StopByName(ctx, "app"))
+
+	mux.Lock()
+	defer mux.Unlock()
+	assert.Equal(t, []map[string]string{
+		{"container": "app", "hook": "prestop"},
+		{"container": "app", "hook": "poststop"},
+	}, calls)
+	assert.Equal(t, 1, host.stopCount("a"))
+}
+
+func TestHookTimeoutLongerThanTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com",
+		"lazyloader.hook.prestart", srv.URL,
+		"lazyloader.hook.prestart.timeout", "5s"))
+	core := newTestCore(t, host)
+	config.Model.Timeout = 50 * time.Millisecond
+
+	cts, err := core.StartHost(context.Background(), "a.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return !cts.isBusy() })
+	assert.Equal(t, PhaseRunning, cts.Phase())
+	assert.Equal(t, 1, host.startCount("a"))
+}
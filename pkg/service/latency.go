@@ -0,0 +1,100 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cold-start latency of one start, measured from the request that triggered it
+type StartSample struct {
+	Time      time.Time     `json:"time"`
+	ToRunning time.Duration `json:"toRunning"` // until the container was started
+	ToReady   time.Duration `json:"toReady"`   // until the container was healthy
+}
+
+// Summary of recent starts of a container
+type StartStats struct {
+	Count                int
+	Last                 StartSample
+	RunningP50, ReadyP50 time.Duration
+	RunningP95, ReadyP95 time.Duration
+}
+
+// Rolling history of the last N starts, per container name
+type latencyTracker struct {
+	mux     sync.Mutex
+	size    int
+	history map[string][]StartSample
+}
+
+func newLatencyTracker(size int) *latencyTracker {
+	if size <= 0 {
+		size = 1
+	}
+	return &latencyTracker{
+		size:    size,
+		history: make(map[string][]StartSample),
+	}
+}
+
+func (s *latencyTracker) record(name string, sample StartSample) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	history := s.history[name]
+	if len(history) >= s.size {
+		history = history[1:]
+	}
+	s.history[name] = append(history, sample)
+}
+
+func (s *latencyTracker) stats(name string) (ret StartStats) {
+	s.mux.Lock()
+	history := append([]StartSample(nil), s.history[name]...)
+	s.mux.Unlock()
+
+	ret.Count = len(history)
+	if ret.Count == 0 {
+		return
+	}
+	ret.Last = history[len(history)-1]
+
+	running := make([]time.Duration, len(history))
+	ready := make([]time.Duration, len(history))
+	for i, sample := range history {
+		running[i] = sample.ToRunning
+		ready[i] = sample.ToReady
+	}
+	ret.RunningP50, ret.RunningP95 = percentile(running, 50), percentile(running, 95)
+	ret.ReadyP50, ret.ReadyP95 = percentile(ready, 50), percentile(ready, 95)
+	return
+}
+
+func (s *latencyTracker) all() map[string]StartStats {
+	s.mux.Lock()
+	names := make([]string, 0, len(s.history))
+	for name := range s.history {
+		names = append(names, name)
+	}
+	s.mux.Unlock()
+
+	ret := make(map[string]StartStats, len(names))
+	for _, name := range names {
+		ret[name] = s.stats(name)
+	}
+	return ret
+}
+
+// Nearest-rank percentile; sorts vals
+func percentile(vals []time.Duration, p int) time.Duration {
+	if len(vals) == 0 {
+		return 0
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	rank := (p*len(vals) + 99) / 100 // ceil
+	if rank < 1 {
+		rank = 1
+	}
+	return vals[rank-1]
+}
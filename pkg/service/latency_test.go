@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	vals := []time.Duration{5, 1, 4, 2, 3, 6, 7, 8, 9, 10}
+	assert.Equal(t, time.Duration(5), percentile(vals, 50))
+	assert.Equal(t, time.Duration(10), percentile(vals, 95))
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+}
+
+func TestLatencyTrackerRolls(t *testing.T) {
+	tracker := newLatencyTracker(3)
+	for i := 1; i <= 5; i++ {
+		tracker.record("a", StartSample{ToRunning: time.Duration(i) * time.Second, ToReady: time.Duration(i*2) * time.Second})
+	}
+
+	stats := tracker.stats("a")
+	assert.Equal(t, 3, stats.Count)
+	assert.Equal(t, 4*time.Second, stats.RunningP50)
+	assert.Equal(t, 10*time.Second, stats.ReadyP95)
+	assert.Equal(t, 5*time.Second, stats.Last.ToRunning)
+	assert.Equal(t, 0, tracker.stats("b").Count)
+}
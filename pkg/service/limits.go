@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	LimitModeQueue = "queue" // wait for a running container to stop
+	LimitModeEvict = "evict" // stop the least-recently-active unpinned container
+)
+
+// Why a container is started
+type startReason int
+
+const (
+	startRequested startReason = iota // by a request for it, or an API call
+	startPrewarm                      // predicted to be used soon; not counted as a wake or for flapping
+)
+
+// A start waiting for room under the running limits
+type queuedStart struct {
+	cts    *ContainerState
+	reason startReason
+	start  func()
+}
+
+// Decide whether a container that was asked to start can start now. If it is over the
+// running limits, it's queued (and room is made, if evicting) and start is called once
+// admitted. Expects s.mux to be held
+func (s *Core) admitLocked(cts *ContainerState, reason startReason, start func()) (bool, error) {
+	switch cts.Phase() {
+	case PhaseStopped, PhaseFailed:
+	default:
+		return cts.requestStart(), nil // already starting, running or queued
+	}
+	cts.markRequested() // start latency includes time spent queued
+
+	if !s.allowStartLocked(cts) {
+		cts.log().Warnf("Not starting, started more than %d times in the last minute", cts.startsPerMin)
+		return false, ErrRateLimited
+	}
+	s.events.reset(cts.cname)
+	if cts.adaptiveDelay && reason == startRequested {
+		s.adaptive.woken(cts.cname, cts.stopDelay)
+	}
+
+	scope, full := s.atLimitLocked(cts)
+	need, estimate, used := s.memoryShortfallLocked(cts)
+	if s.overBudgetLocked(cts, estimate, used) {
+		return false, ErrOverBudget
+	}
+	if (full || need > 0) && reason == startPrewarm {
+		return false, ErrNoRoom // don't make room for a prediction, or hold up requested starts
+	}
+	if full || need > 0 {
+		if cts.transition(PhaseQueued) != nil {
+			return false, nil
+		}
+		s.queue = append(s.queue, queuedStart{cts, reason, start})
+		s.emit(cts, EventQueued, "Waiting for another container to stop (position %d)", len(s.queue))
+
+		if full && config.Model.LimitMode == LimitModeEvict {
+			s.evictLocked(scope)
+		}
+		if need > 0 {
+			decision := AdmissionDecision{time.Now(), cts.Name(), estimate, used, s.memory.budget, "queued", nil}
+			if decision.Evicted = s.evictMemoryLocked(need); len(decision.Evicted) > 0 {
+				decision.Action = "evicted"
+			}
+			s.memory.record(decision)
+		}
+		return false, nil
+	}
+
+	if !cts.requestStart() {
+		return false, nil
+	}
+	s.recordStartLocked(cts)
+	if reason == startRequested {
+		s.checkFlappingLocked(cts)
+	}
+	if s.memory.enabled() {
+		s.memory.record(AdmissionDecision{time.Now(), cts.Name(), estimate, used, s.memory.budget, "started", nil})
+	}
+	return true, nil
+}
+
+// Start queued containers, in order, while there is room
+func (s *Core) admitQueued() {
+	var admitted []queuedStart
+
+	s.mux.Lock()
+	remaining := s.queue[:0]
+	for _, item := range s.queue {
+		if item.cts.Phase() != PhaseQueued {
+			continue // dropped, eg. by StopAll
+		}
+		_, full := s.atLimitLocked(item.cts)
+		need, estimate, used := s.memoryShortfallLocked(item.cts)
+		if s.overBudgetLocked(item.cts, estimate, used) {
+			item.cts.transition(PhaseFailed)
+			continue
+		}
+		if full || need > 0 {
+			remaining = append(remaining, item)
+			continue
+		}
+		if item.cts.transition(PhaseStarting) == nil {
+			s.recordStartLocked(item.cts)
+			if item.reason == startRequested {
+				s.checkFlappingLocked(item.cts)
+			}
+			if s.memory.enabled() {
+				s.memory.record(AdmissionDecision{time.Now(), item.cts.Name(), estimate, used, s.memory.budget, "started", nil})
+			}
+			admitted = append(admitted, item)
+		}
+	}
+	s.queue = remaining
+	s.mux.Unlock()
+
+	for _, item := range admitted {
+		item.cts.log().Info("Admitted queued container")
+		item.start()
+	}
+}
+
+// 1-based position of a container in the start queue, or 0 if it isn't queued
+func (s *Core) QueuePosition(cts *ContainerState) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, item := range s.queue {
+		if item.cts == cts {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// True if the container has started fewer times than its limit in the last minute
+func (s *Core) allowStartLocked(cts *ContainerState) bool {
+	if cts.startsPerMin <= 0 {
+		return true
+	}
+
+	cutoff := time.Now().Add(-time.Minute)
+	recent := s.startTimes[cts.cname][:0]
+	for _, t := range s.startTimes[cts.cname] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	s.startTimes[cts.cname] = recent
+	return len(recent) < cts.startsPerMin
+}
+
+func (s *Core) recordStartLocked(cts *ContainerState) {
+	if cts.startsPerMin > 0 {
+		s.startTimes[cts.cname] = append(s.startTimes[cts.cname], time.Now())
+	}
+}
+
+// Emit a flapping event when a container reaches `flapstarts` starts within `flapwindow`
+func (s *Core) checkFlappingLocked(cts *ContainerState) {
+	if config.Model.FlapStarts <= 0 {
+		return
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-config.Model.FlapWindow)
+	recent := s.flaps[cts.cname][:0]
+	for _, t := range s.flaps[cts.cname] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	s.flaps[cts.cname] = append(recent, now)
+
+	if len(s.flaps[cts.cname]) == config.Model.FlapStarts {
+		s.emit(cts, EventFlapping, "Started %d times in %s", config.Model.FlapStarts, config.Model.FlapWindow)
+	}
+}
+
+// Checks whether starting a container would exceed the global or its group limit.
+// If so, returns the group that is full ("" for the global limit)
+func (s *Core) atLimitLocked(cts *ContainerState) (scope string, full bool) {
+	group := strings.ToLower(cts.group) // config map keys are lowercased
+	groupLimit := config.Model.GroupLimits[group]
+	if group == "" || groupLimit <= 0 {
+		if config.Model.MaxRunning <= 0 {
+			return "", false
+		}
+	}
+
+	running, inGroup := 0, 0
+	for _, other := range s.allStatesLocked() {
+		if other == cts || !other.Phase().occupiesSlot() {
+			continue
+		}
+		running++
+		if group != "" && strings.ToLower(other.group) == group {
+			inGroup++
+		}
+	}
+
+	if group != "" && groupLimit > 0 && inGroup >= groupLimit {
+		return group, true
+	}
+	if config.Model.MaxRunning > 0 && running >= config.Model.MaxRunning {
+		return "", true
+	}
+	return "", false
+}
+
+// Stop the least-recently-active, unpinned container in scope ("" for any), to make room.
+// Doesn't evict if something in scope is already stopping
+func (s *Core) evictLocked(scope string) {
+	if !s.leader {
+		return // the leader does all stopping
+	}
+
+	var victim *ContainerState
+	for _, cts := range s.allStatesLocked() {
+		if scope != "" && strings.ToLower(cts.group) != scope {
+			continue
+		}
+		switch cts.Phase() {
+		case PhaseStopping:
+			return
+		case PhaseRunning, PhaseIdle:
+			if !s.pinnedLocked(cts) && (victim == nil || cts.LastActive().Before(victim.LastActive())) {
+				victim = cts
+			}
+		}
+	}
+
+	if victim == nil || !victim.beginStop(false) {
+		logrus.Warnf("Running limit reached, but nothing can be evicted")
+		return
+	}
+
+	victim.log().Info("Running limit reached, evicting least-recently-active container")
+	go s.stopEvicted(victim)
+}
+
+// Stop a container (marked as stopping) to make room for another
+func (s *Core) stopEvicted(victim *ContainerState) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
+	defer cancel()
+	s.stopContainerAndDependencies(ctx, victim)
+}
+
+func (s *Core) allStatesLocked() []*ContainerState {
+	ret := make([]*ContainerState, 0, len(s.active)+len(s.recreating))
+	for _, cts := range s.active {
+		ret = append(ret, cts)
+	}
+	for _, cts := range s.recreating {
+		ret = append(ret, cts)
+	}
+	return ret
+}
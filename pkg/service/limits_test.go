@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxRunningQueues(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "running", lazyLabels("a.example.com"))
+	host.add("b", "b", "exited", lazyLabels("b.example.com"))
+	core := newTestCore(t, host)
+	config.Model.MaxRunning = 1
+
+	cts, err := core.StartHost(context.Background(), "b.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, PhaseQueued, cts.Phase())
+	assert.Equal(t, 1, core.QueuePosition(cts))
+	assert.Equal(t, 0, host.startCount("b"))
+
+	// Room is made once a is found stopped
+	host.setState("a", "exited")
+	core.Poll()
+	// The same poll may already find it idle
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning || cts.Phase() == PhaseIdle })
+	assert.Equal(t, 0, core.QueuePosition(cts))
+	assert.Equal(t, 1, host.startCount("b"))
+}
+
+// Elector of an instance that is never the leader
+type followerElector struct{}
+
+func (followerElector) IsLeader(ctx context.Context) bool { return false }
+func (followerElector) Close() error                      { return nil }
+
+func TestFollowerAdmitsQueued(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "running", lazyLabels("a.example.com"))
+	host.add("b", "b", "exited", lazyLabels("b.example.com"))
+	setupTestConfig()
+	config.Model.MaxRunning = 1
+	core, err := New(host, containers.NewDiscovery(host), followerElector{}, time.Hour)
+	assert.NoError(t, err)
+	defer core.Close()
+	core.Poll()
+
+	cts, err := core.StartHost(context.Background(), "b.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, PhaseQueued, cts.Phase())
+
+	// The leader stops a; this instance finds it gone
+	host.setState("a", "exited")
+	core.Poll()
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+	assert.Equal(t, 0, host.stopCount("a"))
+}
+
+func TestGroupLimitEvictsLeastRecentlyActive(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "running", lazyLabels("a.example.com", "lazyloader.group", "Small"))
+	host.add("b", "b", "running", lazyLabels("b.example.com", "lazyloader.group", "small", "lazyloader.pin", "true"))
+	host.add("c", "c", "running", lazyLabels("c.example.com"))
+	host.add("d", "d", "exited", lazyLabels("d.example.com", "lazyloader.group", "small"))
+	core := newTestCore(t, host)
+	config.Model.GroupLimits = map[string]int{"small": 2}
+	config.Model.LimitMode = LimitModeEvict
+
+	cts, err := core.StartHost(context.Background(), "d.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+
+	assert.Equal(t, 1, host.stopCount("a"))
+	assert.Equal(t, 0, host.stopCount("b")) // pinned
+	assert.Equal(t, 0, host.stopCount("c")) // other group
+}
+
+func TestStartsPerMinute(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "exited", lazyLabels("a.example.com", "lazyloader.startsperminute", "1"))
+	core := newTestCore(t, host)
+
+	cts, err := core.StartHost(context.Background(), "a.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+
+	host.setState("a", "exited")
+	cts.discoverStopped()
+	_, err = core.StartHost(context.Background(), "a.example.com")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, host.startCount("a"))
+}
+
+func TestFlappingEvent(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com"))
+	core := newTestCore(t, host)
+	config.Model.FlapStarts = 2
+	config.Model.FlapWindow = time.Minute
+	_, events, unsubscribe := core.Subscribe("app")
+	defer unsubscribe()
+	ctx := context.Background()
+
+	var types []EventType
+	for i := 0; i < 2; i++ {
+		cts, err := core.StartByName(ctx, "app")
+		assert.NoError(t, err)
+		waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+		assert.NoError(t, core.StopByName(ctx, "app"))
+	}
+	for len(events) > 0 {
+		types = append(types, (<-events).Type)
+	}
+	assert.Contains(t, types, EventStopped)
+	assert.Contains(t, types, EventFlapping)
+}
+
+func TestQueuedTimeCountsAsLatency(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "running", lazyLabels("a.example.com"))
+	host.add("b", "b", "exited", lazyLabels("b.example.com"))
+	core := newTestCore(t, host)
+	config.Model.MaxRunning = 1
+
+	cts, err := core.StartHost(context.Background(), "b.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, PhaseQueued, cts.Phase())
+	time.Sleep(50 * time.Millisecond)
+
+	host.setState("a", "exited")
+	core.Poll()
+	waitFor(t, func() bool { return core.StartStats("b").Count == 1 })
+	assert.GreaterOrEqual(t, core.StartStats("b").Last.ToReady, 50*time.Millisecond)
+}
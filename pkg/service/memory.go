@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+// Where a container's memory estimate came from
+const (
+	MemorySourceLimit   = "limit"   // configured memory limit (from inspect)
+	MemorySourcePeak    = "peak"    // highest usage observed by polling
+	MemorySourceDefault = "default" // config memorydefault
+)
+
+// Admission decision made against the memory budget, for the status page
+type AdmissionDecision struct {
+	Time      time.Time
+	Container string
+	Estimate  int64 // of the container asking to start
+	Used      int64 // estimated use of everything running
+	Budget    int64
+	Action    string   // started, queued, evicted or rejected
+	Evicted   []string // containers stopped to make room
+}
+
+const admissionHistoryLen = 20
+
+// Memory estimates per container name, and recent admission decisions
+type memoryTracker struct {
+	budget int64 // 0 is disabled
+	dflt   int64
+
+	mux       sync.Mutex
+	limits    map[string]int64
+	peaks     map[string]int64
+	decisions []AdmissionDecision
+}
+
+// Parse the memory budget and default estimate from config (eg. "4g"); empty is 0
+func parseMemoryConfig() (budget, dflt int64, err error) {
+	if config.Model.MemoryBudget != "" {
+		if budget, err = units.RAMInBytes(config.Model.MemoryBudget); err != nil {
+			return
+		}
+	}
+	if config.Model.MemoryDefault != "" {
+		dflt, err = units.RAMInBytes(config.Model.MemoryDefault)
+	}
+	return
+}
+
+func newMemoryTracker(budget, dflt int64) *memoryTracker {
+	return &memoryTracker{
+		budget: budget,
+		dflt:   dflt,
+		limits: make(map[string]int64),
+		peaks:  make(map[string]int64),
+	}
+}
+
+func (s *memoryTracker) enabled() bool {
+	return s.budget > 0
+}
+
+func (s *memoryTracker) hasLimit(name string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, ok := s.limits[name]
+	return ok
+}
+
+// Record a container's configured memory limit (0 if unlimited)
+func (s *memoryTracker) setLimit(name string, limit int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.limits[name] = limit
+}
+
+// Record observed memory usage, keeping the peak
+func (s *memoryTracker) observe(name string, usage int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if usage > s.peaks[name] {
+		s.peaks[name] = usage
+	}
+}
+
+// Estimated memory use of a container: its limit if it has one, otherwise the
+// highest usage seen, otherwise the configured default
+func (s *memoryTracker) estimate(name string) (int64, string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if limit := s.limits[name]; limit > 0 {
+		return limit, MemorySourceLimit
+	}
+	if peak := s.peaks[name]; peak > 0 {
+		return peak, MemorySourcePeak
+	}
+	return s.dflt, MemorySourceDefault
+}
+
+func (s *memoryTracker) record(decision AdmissionDecision) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(s.decisions) >= admissionHistoryLen {
+		s.decisions = s.decisions[1:]
+	}
+	s.decisions = append(s.decisions, decision)
+}
+
+// Sum of the estimates of all containers taking up memory, except one. Expects s.mux to be held
+func (s *Core) memoryUsedLocked(except *ContainerState) (used int64) {
+	for _, cts := range s.allStatesLocked() {
+		if cts != except && cts.Phase().occupiesSlot() {
+			est, _ := s.memory.estimate(cts.cname)
+			used += est
+		}
+	}
+	return
+}
+
+// How much memory is missing to start a container within the budget (0 if it fits).
+// Expects s.mux to be held
+func (s *Core) memoryShortfallLocked(cts *ContainerState) (need, estimate, used int64) {
+	if !s.memory.enabled() {
+		return 0, 0, 0
+	}
+	estimate, _ = s.memory.estimate(cts.cname)
+	used = s.memoryUsedLocked(cts)
+	if need = used + estimate - s.memory.budget; need < 0 {
+		need = 0
+	}
+	return
+}
+
+// True if a container's estimate is over the whole budget, so it can never be started
+// within it. Rejects the start with a failed event. Expects s.mux to be held
+func (s *Core) overBudgetLocked(cts *ContainerState, estimate, used int64) bool {
+	if !s.memory.enabled() || estimate <= s.memory.budget {
+		return false
+	}
+	s.memory.record(AdmissionDecision{time.Now(), cts.Name(), estimate, used, s.memory.budget, "rejected", nil})
+	s.emit(cts, EventFailed, "Estimated memory of %s is over the budget of %s",
+		units.BytesSize(float64(estimate)), units.BytesSize(float64(s.memory.budget)))
+	return true
+}
+
+// Stop idle-longest, unpinned containers until there is `need` memory free (counting
+// what is already stopping). Returns the names of those stopped; none if it isn't
+// possible to make enough room. Expects s.mux to be held
+func (s *Core) evictMemoryLocked(need int64) (evicted []string) {
+	if !s.leader {
+		return nil // the leader does all stopping
+	}
+
+	var candidates []*ContainerState
+	for _, cts := range s.allStatesLocked() {
+		switch cts.Phase() {
+		case PhaseStopping:
+			est, _ := s.memory.estimate(cts.cname)
+			need -= est
+		case PhaseRunning, PhaseIdle:
+			if !s.pinnedLocked(cts) {
+				candidates = append(candidates, cts)
+			}
+		}
+	}
+	if need <= 0 {
+		return nil // already making room
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastActive().Before(candidates[j].LastActive())
+	})
+
+	var victims []*ContainerState
+	for _, cts := range candidates {
+		if need <= 0 {
+			break
+		}
+		est, _ := s.memory.estimate(cts.cname)
+		victims = append(victims, cts)
+		need -= est
+	}
+	if need > 0 {
+		logrus.Warnf("Memory budget reached, but not enough can be stopped to make room")
+		return nil
+	}
+
+	for _, victim := range victims {
+		if !victim.beginStop(false) {
+			continue
+		}
+		victim.log().Info("Memory budget reached, stopping idle container to make room")
+		evicted = append(evicted, victim.Name())
+		go s.stopEvicted(victim)
+	}
+	return
+}
+
+// Learn a container's memory limit, if the budget is in use and it isn't known yet
+func (s *Core) inspectMemoryLimit(ctx context.Context, cid, name string) {
+	if !s.memory.enabled() || s.memory.hasLimit(name) {
+		return
+	}
+	info, err := s.client.ContainerInspect(ctx, cid)
+	if err != nil {
+		containers.Logger(cid, name).Debugf("Unable to inspect memory limit: %v", err)
+		return
+	}
+	if info.ContainerJSONBase != nil && info.HostConfig != nil {
+		s.memory.setLimit(name, info.HostConfig.Memory)
+	}
+}
+
+// Memory usage from stats; cgroup v2 doesn't report a max, so the peak is tracked by polling
+func statsMemoryUsage(stats *types.StatsJSON) int64 {
+	if stats.MemoryStats.MaxUsage > stats.MemoryStats.Usage {
+		return int64(stats.MemoryStats.MaxUsage)
+	}
+	return int64(stats.MemoryStats.Usage)
+}
+
+// Memory budget and recent admission decisions, for the status page
+type MemoryStatus struct {
+	Budget    int64
+	Used      int64
+	Decisions []AdmissionDecision // newest first
+}
+
+func (s *Core) MemoryStatus() MemoryStatus {
+	s.mux.Lock()
+	used := s.memoryUsedLocked(nil)
+	s.mux.Unlock()
+
+	s.memory.mux.Lock()
+	defer s.memory.mux.Unlock()
+	ret := MemoryStatus{
+		Budget:    s.memory.budget,
+		Used:      used,
+		Decisions: make([]AdmissionDecision, 0, len(s.memory.decisions)),
+	}
+	for i := len(s.memory.decisions) - 1; i >= 0; i-- {
+		ret.Decisions = append(ret.Decisions, s.memory.decisions[i])
+	}
+	return ret
+}
+
+// Memory estimate of a container, and where it came from
+func (s *Core) MemoryEstimate(cts *ContainerState) (int64, string) {
+	return s.memory.estimate(cts.cname)
+}
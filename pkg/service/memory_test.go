@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryEstimate(t *testing.T) {
+	tracker := newMemoryTracker(1000, 50)
+	est, source := tracker.estimate("a")
+	assert.Equal(t, int64(50), est)
+	assert.Equal(t, MemorySourceDefault, source)
+
+	tracker.observe("a", 200)
+	tracker.observe("a", 100)
+	est, source = tracker.estimate("a")
+	assert.Equal(t, int64(200), est)
+	assert.Equal(t, MemorySourcePeak, source)
+
+	tracker.setLimit("a", 300)
+	est, source = tracker.estimate("a")
+	assert.Equal(t, int64(300), est)
+	assert.Equal(t, MemorySourceLimit, source)
+}
+
+func TestMemoryBudgetEvictsIdleLongest(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "running", lazyLabels("a.example.com"))
+	host.add("b", "b", "running", lazyLabels("b.example.com"))
+	host.add("c", "c", "exited", lazyLabels("c.example.com"))
+	host.setMemory("a", 0, 400<<20)
+	host.setMemory("b", 400<<20, 100<<20)
+	host.setMemory("c", 300<<20, 0)
+
+	setupTestConfig()
+	config.Model.MemoryBudget = "1g"
+	core, err := New(host, containers.NewDiscovery(host), nil, time.Hour)
+	assert.NoError(t, err)
+	t.Cleanup(func() { core.Close() })
+	core.Poll() // observe usage
+
+	// b was active more recently than a
+	for _, cts := range core.ActiveContainers() {
+		if cts.ContainerName() == "a" {
+			cts.mux.Lock()
+			cts.lastActivity = time.Now().Add(-time.Hour)
+			cts.mux.Unlock()
+		}
+	}
+
+	cts, err := core.StartHost(context.Background(), "c.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+	assert.Equal(t, 1, host.stopCount("a"))
+	assert.Equal(t, 0, host.stopCount("b"))
+
+	status := core.MemoryStatus()
+	assert.Equal(t, int64(1<<30), status.Budget)
+	if assert.NotEmpty(t, status.Decisions) {
+		assert.Equal(t, "started", status.Decisions[0].Action)
+		assert.Equal(t, "evicted", status.Decisions[len(status.Decisions)-1].Action)
+	}
+}
+
+func TestMemoryBudgetRejectsOversized(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "exited", lazyLabels("a.example.com"))
+	host.setMemory("a", 2<<30, 0)
+
+	setupTestConfig()
+	config.Model.MemoryBudget = "1g"
+	core, err := New(host, containers.NewDiscovery(host), nil, time.Hour)
+	assert.NoError(t, err)
+	t.Cleanup(func() { core.Close() })
+	_, events, unsubscribe := core.Subscribe("a")
+	defer unsubscribe()
+
+	_, err = core.StartHost(context.Background(), "a.example.com")
+	assert.ErrorIs(t, err, ErrOverBudget)
+	assert.Equal(t, 0, host.startCount("a"))
+	assert.Equal(t, EventFailed, (<-events).Type)
+	assert.Equal(t, "rejected", core.MemoryStatus().Decisions[0].Action)
+}
@@ -0,0 +1,388 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var errNotImplemented = errors.New("not implemented")
+
+type mockContainer struct {
+	types.Container
+	rx, tx   uint64
+	memLimit int64
+	memUsage uint64
+}
+
+// In-memory docker host for tests
+type mockHost struct {
+	mux         sync.Mutex
+	containers  map[string]*mockContainer
+	starts      map[string]int
+	stops       map[string]int
+	ops         map[string]int // "op:id" -> count, for other operations (eg. "pause:a")
+	lists       int
+	execs       []mockExec
+	checkpoints map[string][]string // id -> checkpoint names, oldest first
+
+	execOutput    string
+	execExitCode  int
+	startDelay    time.Duration
+	stopDelay     time.Duration
+	autoRemove    bool // containers are removed when they stop, like with `--rm`
+	checkpointErr error
+	restoreErr    error
+}
+
+func newMockHost() *mockHost {
+	return &mockHost{
+		containers:  make(map[string]*mockContainer),
+		starts:      make(map[string]int),
+		stops:       make(map[string]int),
+		ops:         make(map[string]int),
+		checkpoints: make(map[string][]string),
+	}
+}
+
+func (s *mockHost) addCheckpoints(id string, names ...string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.checkpoints[id] = append(s.checkpoints[id], names...)
+}
+
+func (s *mockHost) checkpointsOf(id string) []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]string(nil), s.checkpoints[id]...)
+}
+
+func (s *mockHost) add(id, name, state string, labels map[string]string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.containers[id] = &mockContainer{
+		Container: types.Container{
+			ID:     id,
+			Names:  []string{"/" + name},
+			State:  state,
+			Labels: labels,
+		},
+	}
+}
+
+func (s *mockHost) setMemory(id string, limit int64, usage uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.containers[id].memLimit = limit
+	s.containers[id].memUsage = usage
+}
+
+func (s *mockHost) startCount(id string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.starts[id]
+}
+
+func (s *mockHost) stopCount(id string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.stops[id]
+}
+
+func (s *mockHost) opCount(op, id string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.ops[op+":"+id]
+}
+
+func (s *mockHost) exists(id string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, ok := s.containers[id]
+	return ok
+}
+
+func (s *mockHost) setState(id, state string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if ct, ok := s.containers[id]; ok {
+		ct.State = state
+	}
+}
+
+func (s *mockHost) Info(ctx context.Context) (types.Info, error) {
+	return types.Info{Name: "mock"}, nil
+}
+
+func (s *mockHost) ContainerList(ctx context.Context, clo types.ContainerListOptions) ([]types.Container, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.lists++
+
+	var ret []types.Container
+	for _, ct := range s.containers {
+		if !clo.All && ct.State != "running" && ct.State != "paused" {
+			continue
+		}
+		if !matchesLabelFilters(ct.Labels, clo.Filters.Get("label")) {
+			continue
+		}
+		ret = append(ret, ct.Container)
+	}
+	return ret, nil
+}
+
+func matchesLabelFilters(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		k, v, hasValue := strings.Cut(filter, "=")
+		actual, ok := labels[k]
+		if !ok || (hasValue && actual != v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *mockHost) ContainerStart(ctx context.Context, id string, opt types.ContainerStartOptions) error {
+	time.Sleep(s.startDelay)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if opt.CheckpointID != "" {
+		s.ops["restore:"+id+":"+opt.CheckpointID]++
+		if s.restoreErr != nil {
+			return s.restoreErr
+		}
+	}
+	s.starts[id]++
+	if ct, ok := s.containers[id]; ok {
+		ct.State = "running"
+		return nil
+	}
+	return errors.New("no such container")
+}
+
+func (s *mockHost) ContainerStop(ctx context.Context, id string, opt container.StopOptions) error {
+	time.Sleep(s.stopDelay)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stops[id]++
+	if ct, ok := s.containers[id]; ok {
+		ct.State = "exited"
+		if s.autoRemove {
+			delete(s.containers, id)
+		}
+		return nil
+	}
+	return errors.New("no such container")
+}
+
+func (s *mockHost) ContainerPause(ctx context.Context, id string) error {
+	s.mux.Lock()
+	s.ops["pause:"+id]++
+	s.mux.Unlock()
+	s.setState(id, "paused")
+	return nil
+}
+
+func (s *mockHost) ContainerUnpause(ctx context.Context, id string) error {
+	s.mux.Lock()
+	s.ops["unpause:"+id]++
+	s.mux.Unlock()
+	s.setState(id, "running")
+	return nil
+}
+
+func (s *mockHost) ContainerKill(ctx context.Context, id, signal string) error {
+	s.mux.Lock()
+	s.ops["kill:"+id]++
+	s.ops["kill:"+id+":"+signal]++
+	s.mux.Unlock()
+	s.setState(id, "exited")
+	return nil
+}
+
+func (s *mockHost) ContainerRemove(ctx context.Context, id string, opt types.ContainerRemoveOptions) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.ops["remove:"+id]++
+	if _, ok := s.containers[id]; !ok {
+		return errdefs.NotFound(errors.New("no such container"))
+	}
+	delete(s.containers, id)
+	return nil
+}
+
+// A command exec'd in a container
+type mockExec struct {
+	container string
+	cmd       []string
+	env       []string
+}
+
+func (s *mockHost) execCalls() []mockExec {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]mockExec(nil), s.execs...)
+}
+
+func (s *mockHost) ContainerExecCreate(ctx context.Context, id string, config types.ExecConfig) (types.IDResponse, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ct, ok := s.containers[id]
+	if !ok {
+		for _, candidate := range s.containers {
+			if candidate.Names[0] == "/"+id {
+				ct, ok = candidate, true
+			}
+		}
+	}
+	if !ok || ct.State != "running" {
+		return types.IDResponse{}, errors.New("container is not running")
+	}
+	s.execs = append(s.execs, mockExec{ct.ID, config.Cmd, config.Env})
+	return types.IDResponse{ID: "exec-" + ct.ID}, nil
+}
+
+func (s *mockHost) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	s.mux.Lock()
+	output := s.execOutput
+	s.mux.Unlock()
+
+	conn, remote := net.Pipe()
+	go func() {
+		stdcopy.NewStdWriter(remote, stdcopy.Stdout).Write([]byte(output))
+		remote.Close()
+	}()
+	return types.NewHijackedResponse(conn, ""), nil
+}
+
+func (s *mockHost) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return types.ContainerExecInspect{ExecID: execID, ExitCode: s.execExitCode}, nil
+}
+
+func (s *mockHost) CheckpointCreate(ctx context.Context, id string, opt types.CheckpointCreateOptions) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.ops["checkpoint:"+id]++
+	if s.checkpointErr != nil {
+		return s.checkpointErr
+	}
+	ct, ok := s.containers[id]
+	if !ok {
+		return errors.New("no such container")
+	}
+	s.checkpoints[id] = append(s.checkpoints[id], opt.CheckpointID)
+	if opt.Exit {
+		ct.State = "exited"
+	}
+	return nil
+}
+
+func (s *mockHost) CheckpointList(ctx context.Context, id string, opt types.CheckpointListOptions) ([]types.Checkpoint, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var ret []types.Checkpoint
+	for _, name := range s.checkpoints[id] {
+		ret = append(ret, types.Checkpoint{Name: name})
+	}
+	return ret, nil
+}
+
+func (s *mockHost) CheckpointDelete(ctx context.Context, id string, opt types.CheckpointDeleteOptions) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	names := s.checkpoints[id]
+	for i, name := range names {
+		if name == opt.CheckpointID {
+			s.checkpoints[id] = append(names[:i:i], names[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no such checkpoint")
+}
+
+func (s *mockHost) ContainerStatsOneShot(ctx context.Context, id string) (types.ContainerStats, error) {
+	s.mux.Lock()
+	ct, ok := s.containers[id]
+	var stats types.StatsJSON
+	if ok && ct.State == "running" {
+		stats.PidsStats.Current = 1
+		stats.Networks = map[string]types.NetworkStats{
+			"eth0": {RxBytes: ct.rx, TxBytes: ct.tx},
+		}
+		stats.MemoryStats.Usage = ct.memUsage
+	}
+	s.mux.Unlock()
+
+	data, _ := json.Marshal(stats)
+	return types.ContainerStats{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (s *mockHost) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ct, ok := s.containers[id]
+	if !ok {
+		return types.ContainerJSON{}, errors.New("no such container")
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   id,
+			Name: ct.Names[0],
+			State: &types.ContainerState{
+				Status:  ct.State,
+				Running: ct.State == "running",
+				Paused:  ct.State == "paused",
+			},
+			HostConfig: &container.HostConfig{
+				Resources: container.Resources{Memory: ct.memLimit},
+			},
+		},
+		Config: &container.Config{Labels: ct.Labels},
+	}, nil
+}
+
+// Creates a container with the ID "<name>-<n>", for the n-th container created with the name
+func (s *mockHost) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.CreateResponse, error) {
+	s.mux.Lock()
+	s.ops["create:"+containerName]++
+	id := fmt.Sprintf("%s-%d", containerName, s.ops["create:"+containerName])
+	s.mux.Unlock()
+	s.add(id, containerName, "created", config.Labels)
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (s *mockHost) NetworkConnect(ctx context.Context, network, id string, config *network.EndpointSettings) error {
+	return nil
+}
+
+// Every image is present
+func (s *mockHost) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: image}, nil, nil
+}
+
+func (s *mockHost) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	return nil, errNotImplemented
+}
+
+func (s *mockHost) Close() error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lifecycle phase of a managed container
+//
+//	Stopped → Starting → WaitingReady → Running ⇄ Idle → Stopping → Stopped
+//
+// plus Failed, if starting didn't succeed, and Queued, if a start is waiting for
+// room under the running limits
+type Phase int
+
+const (
+	PhaseStopped Phase = iota
+	PhaseQueued
+	PhaseStarting
+	PhaseWaitingReady
+	PhaseRunning
+	PhaseIdle
+	PhaseStopping
+	PhaseFailed
+)
+
+var phaseNames = [...]string{
+	PhaseStopped:      "stopped",
+	PhaseQueued:       "queued",
+	PhaseStarting:     "starting",
+	PhaseWaitingReady: "waiting",
+	PhaseRunning:      "running",
+	PhaseIdle:         "idle",
+	PhaseStopping:     "stopping",
+	PhaseFailed:       "failed",
+}
+
+func (s Phase) String() string {
+	if s < 0 || int(s) >= len(phaseNames) {
+		return fmt.Sprintf("phase(%d)", int(s))
+	}
+	return phaseNames[s]
+}
+
+func (s Phase) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// from -> allowed to
+var validTransitions = map[Phase][]Phase{
+	PhaseStopped:      {PhaseQueued, PhaseStarting, PhaseRunning},
+	PhaseQueued:       {PhaseStarting, PhaseStopped, PhaseFailed},
+	PhaseStarting:     {PhaseWaitingReady, PhaseFailed, PhaseStopping},
+	PhaseWaitingReady: {PhaseRunning, PhaseFailed, PhaseStopping},
+	PhaseRunning:      {PhaseIdle, PhaseStopping, PhaseStopped},
+	PhaseIdle:         {PhaseRunning, PhaseStopping, PhaseStopped},
+	PhaseStopping:     {PhaseStopped, PhaseRunning},
+	PhaseFailed:       {PhaseQueued, PhaseStarting, PhaseStopping, PhaseStopped},
+}
+
+func canTransition(from, to Phase) bool {
+	for _, allowed := range validTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// true if a container in this phase counts towards the running limits
+func (s Phase) occupiesSlot() bool {
+	switch s {
+	case PhaseStarting, PhaseWaitingReady, PhaseRunning, PhaseIdle, PhaseStopping:
+		return true
+	}
+	return false
+}
+
+// Record of entering a phase
+type Transition struct {
+	Phase Phase     `json:"phase"`
+	Time  time.Time `json:"time"`
+}
+
+// Transitions kept per container
+const transitionHistoryLen = 16
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhaseTransitions(t *testing.T) {
+	assert.True(t, canTransition(PhaseStopped, PhaseStarting))
+	assert.True(t, canTransition(PhaseIdle, PhaseRunning))
+	assert.False(t, canTransition(PhaseStopped, PhaseStopping))
+	assert.False(t, canTransition(PhaseStopping, PhaseStarting))
+
+	cts := &ContainerState{transitions: []Transition{{PhaseStopped, time.Now()}}}
+	assert.ErrorIs(t, cts.transition(PhaseRunning), nil)
+	assert.ErrorIs(t, cts.transition(PhaseStarting), ErrInvalidTransition)
+	assert.Equal(t, PhaseRunning, cts.Phase())
+	assert.Len(t, cts.Transitions(), 2)
+	assert.Equal(t, "running", cts.Phase().String())
+}
+
+func TestRestartQueuedWhileStopping(t *testing.T) {
+	host := newMockHost()
+	host.stopDelay = 200 * time.Millisecond
+	core := newTestCore(t, host)
+	host.add("a", "a", "running", lazyLabels("a.example.com", "lazyloader.stopdelay", "0s"))
+
+	pollDone := make(chan struct{})
+	go func() {
+		core.Poll()
+		close(pollDone)
+	}()
+	waitFor(t, func() bool {
+		active := core.ActiveContainers()
+		return len(active) == 1 && active[0].Phase() == PhaseStopping
+	})
+
+	cts, err := core.StartHost(context.Background(), "a.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, PhaseStopping, cts.Phase())
+
+	<-pollDone
+	assert.Equal(t, PhaseStopped, cts.Phase())
+	assert.Equal(t, 1, host.stopCount("a"))
+
+	// Restarted right after stopping, as a new state
+	waitFor(t, func() bool {
+		active := core.ActiveContainers()
+		return len(active) == 1 && active[0].Phase() == PhaseRunning
+	})
+	assert.Equal(t, 1, host.startCount("a"))
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Wakes needed in a weekday-hour before it is predicted, however likely
+const prewarmMinWakes = 2
+
+// Which weekday-hours a container was woken in, counting each hour once
+type wakeModel struct {
+	First  time.Time  `json:"first"`
+	Counts [7][24]int `json:"counts"` // weekday -> hour -> days woken
+	Last   time.Time  `json:"last"`   // hour of the last counted wake
+}
+
+// Weeks the model has been recording for, including the current one
+func (s *wakeModel) weeks(now time.Time) int {
+	return int(now.Sub(s.First)/(7*24*time.Hour)) + 1
+}
+
+// Fraction of weeks the container was woken in the weekday-hour of t
+func (s *wakeModel) probability(t time.Time, now time.Time) float64 {
+	count := s.Counts[t.Weekday()][t.Hour()]
+	if count < prewarmMinWakes {
+		return 0
+	}
+	p := float64(count) / float64(s.weeks(now))
+	if p > 1 {
+		p = 1
+	}
+	return p
+}
+
+// Per-container wake models, optionally persisted to a file
+type prewarmTracker struct {
+	path      string
+	threshold float64
+
+	saveMux   sync.Mutex // serializes writing path (and its .tmp)
+	mux       sync.Mutex
+	models    map[string]*wakeModel
+	prewarmed map[string]time.Time // name -> hour last pre-started for
+}
+
+func newPrewarmTracker(path string, threshold float64) (*prewarmTracker, error) {
+	ret := &prewarmTracker{
+		path:      path,
+		threshold: threshold,
+		models:    make(map[string]*wakeModel),
+		prewarmed: make(map[string]time.Time),
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &ret.models); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// Record that a container was woken by a request
+func (s *prewarmTracker) recordWake(name string, t time.Time) {
+	hour := t.Truncate(time.Hour)
+
+	s.mux.Lock()
+	model, ok := s.models[name]
+	if !ok {
+		model = &wakeModel{First: t}
+		s.models[name] = model
+	}
+	if !model.Last.Before(hour) {
+		s.mux.Unlock()
+		return // already counted this hour
+	}
+	model.Last = hour
+	model.Counts[t.Weekday()][t.Hour()]++
+	s.mux.Unlock()
+
+	if err := s.save(); err != nil {
+		logrus.Warnf("Unable to save wake history: %v", err)
+	}
+}
+
+// True if a container should be pre-started now, for its predicted use at the start of
+// the hour `lead` from now. Each hour is only pre-started for once
+func (s *prewarmTracker) due(name string, now time.Time, lead time.Duration) bool {
+	upcoming := now.Add(lead).Truncate(time.Hour)
+	if !upcoming.After(now) {
+		return false // not within lead of the next hour
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	model, ok := s.models[name]
+	if !ok || s.prewarmed[name].Equal(upcoming) {
+		return false
+	}
+	if model.probability(upcoming, now) < s.threshold {
+		return false
+	}
+	s.prewarmed[name] = upcoming
+	return true
+}
+
+// Record network activity of a running container. A pre-started container isn't woken
+// by requests, so activity around the hour it was pre-started for counts as a wake then
+func (s *prewarmTracker) recordUse(name string, t time.Time, lead time.Duration) {
+	s.mux.Lock()
+	hour, ok := s.prewarmed[name]
+	s.mux.Unlock()
+
+	if ok && !t.Before(hour.Add(-lead)) && t.Before(hour.Add(time.Hour)) {
+		s.recordWake(name, hour)
+	}
+}
+
+func (s *prewarmTracker) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.saveMux.Lock()
+	defer s.saveMux.Unlock()
+	s.mux.Lock()
+	data, err := json.Marshal(s.models)
+	s.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Weekday-hour a container is predicted to be used in
+type PredictedHour struct {
+	Weekday     time.Weekday
+	Hour        int
+	Probability float64
+}
+
+// eg. "Mon 09:00 (80%)"
+func (s PredictedHour) String() string {
+	return fmt.Sprintf("%.3s %02d:00 (%.0f%%)", s.Weekday, s.Hour, s.Probability*100)
+}
+
+// Predicted use of a container, from the weekday-hours it was woken in
+type Prediction struct {
+	Container string
+	Prewarm   bool // has the prewarm label
+	Weeks     int  // of history
+	Wakes     int
+	Hours     []PredictedHour // at or above the threshold, from Sunday
+	Next      time.Time       // start of the next predicted hour (zero if none)
+}
+
+func (s *prewarmTracker) predictions(now time.Time) []Prediction {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make([]Prediction, 0, len(s.models))
+	for name, model := range s.models {
+		pred := Prediction{Container: name, Weeks: model.weeks(now)}
+		for day := range model.Counts {
+			for _, count := range model.Counts[day] {
+				pred.Wakes += count
+			}
+		}
+		// The next week of hours, in order
+		start := now.Truncate(time.Hour)
+		for i := 1; i <= 7*24; i++ {
+			t := start.Add(time.Duration(i) * time.Hour)
+			if p := model.probability(t, now); p >= s.threshold && p > 0 {
+				if pred.Next.IsZero() {
+					pred.Next = t
+				}
+				pred.Hours = append(pred.Hours, PredictedHour{t.Weekday(), t.Hour(), p})
+			}
+		}
+		sort.Slice(pred.Hours, func(i, j int) bool {
+			a, b := pred.Hours[i], pred.Hours[j]
+			return a.Weekday < b.Weekday || (a.Weekday == b.Weekday && a.Hour < b.Hour)
+		})
+		ret = append(ret, pred)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Container < ret[j].Container
+	})
+	return ret
+}
+
+// Pre-start stopped containers with the prewarm label that are predicted to be used
+// within `prewarmlead`
+func (s *Core) prewarmSync(ctx context.Context) {
+	if config.Model.PrewarmLead <= 0 {
+		return
+	}
+
+	cts, err := s.discovery.FindAllLazyload(ctx, true)
+	if err != nil {
+		logrus.Warnf("Unable to list containers to pre-warm: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range cts {
+		ct := &cts[i]
+		if prewarm, _ := ct.ConfigBool("prewarm", false); !prewarm || ct.IsRunning() || s.IsStarted(ct.Name()) {
+			continue
+		}
+		if !s.prewarm.due(ct.Name(), now, config.Model.PrewarmLead) {
+			continue
+		}
+
+		ct.Log().Info("Pre-warming container, predicted to be used soon")
+		s.inspectMemoryLimit(ctx, ct.ID, ct.Name())
+		if _, err := s.startContainer(ctx, ct, startPrewarm); err != nil {
+			ct.Log().Warnf("Unable to pre-warm: %v", err)
+		}
+	}
+}
+
+// Predicted use of each container that has been woken, for the status page
+func (s *Core) Predictions(ctx context.Context) []Prediction {
+	ret := s.prewarm.predictions(time.Now())
+
+	if cts, err := s.discovery.FindAllLazyload(ctx, true); err == nil {
+		prewarm := make(map[string]bool)
+		for i := range cts {
+			prewarm[cts[i].Name()], _ = cts[i].ConfigBool("prewarm", false)
+		}
+		for i := range ret {
+			ret[i].Prewarm = prewarm[ret[i].Container]
+		}
+	}
+	return ret
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrewarmPrediction(t *testing.T) {
+	tracker, _ := newPrewarmTracker("", 0.5)
+	monday := time.Date(2026, 10, 5, 9, 2, 0, 0, time.Local)
+
+	// Woken two Mondays at 9, once a Tuesday at 14; counted once per hour
+	tracker.recordWake("tool", monday)
+	tracker.recordWake("tool", monday.Add(10*time.Minute))
+	tracker.recordWake("tool", monday.Add(29*time.Hour))
+	tracker.recordWake("tool", monday.Add(7*24*time.Hour))
+
+	now := monday.Add(14*24*time.Hour - 2*time.Hour) // Monday 7:02, two weeks later
+	preds := tracker.predictions(now)
+	if assert.Len(t, preds, 1) {
+		assert.Equal(t, 2, preds[0].Weeks)
+		assert.Equal(t, 3, preds[0].Wakes)
+		if assert.Len(t, preds[0].Hours, 1) {
+			assert.Equal(t, PredictedHour{time.Monday, 9, 1}, preds[0].Hours[0])
+		}
+		assert.Equal(t, monday.Add(14*24*time.Hour).Truncate(time.Hour), preds[0].Next)
+	}
+
+	// Only due within the lead time of the hour, and only once
+	assert.False(t, tracker.due("tool", now, 30*time.Minute))
+	soon := now.Add(100 * time.Minute) // 8:42
+	assert.True(t, tracker.due("tool", soon, 30*time.Minute))
+	assert.False(t, tracker.due("tool", soon.Add(time.Minute), 30*time.Minute))
+	assert.False(t, tracker.due("other", soon, 30*time.Minute))
+
+	// Use of the pre-started container counts as a wake in the predicted hour
+	tracker.recordUse("tool", soon.Add(time.Minute), 30*time.Minute)
+	assert.Equal(t, 3, tracker.models["tool"].Counts[time.Monday][9])
+}
+
+func TestPrewarmPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wakes.json")
+	tracker, _ := newPrewarmTracker(path, 0.5)
+	tracker.recordWake("tool", time.Now())
+
+	loaded, err := newPrewarmTracker(path, 0.5)
+	assert.NoError(t, err)
+	assert.Len(t, loaded.predictions(time.Now()), 1)
+}
+
+func TestPrewarmIsNotAWake(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com", "lazyloader.adaptivedelay", "true"))
+	core := newTestCore(t, host)
+	config.Model.FlapStarts = 1
+	config.Model.FlapWindow = time.Minute
+	core.adaptive.idleStopped("app")
+	_, events, unsubscribe := core.Subscribe("app")
+	defer unsubscribe()
+	ctx := context.Background()
+
+	cts, err := core.discovery.FindAllLazyload(ctx, true)
+	assert.NoError(t, err)
+	state, err := core.startContainer(ctx, &cts[0], startPrewarm)
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return state.Phase() == PhaseRunning })
+
+	for len(events) > 0 {
+		assert.NotEqual(t, EventFlapping, (<-events).Type)
+	}
+	assert.Contains(t, core.adaptive.stopped, "app") // still waiting for a real wake
+}
+
+func TestPrewarmDoesNotEvict(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "a", "running", lazyLabels("a.example.com"))
+	host.add("b", "b", "exited", lazyLabels("b.example.com"))
+	core := newTestCore(t, host)
+	config.Model.MaxRunning = 1
+	config.Model.LimitMode = LimitModeEvict
+	ctx := context.Background()
+
+	ct, err := core.FindHost(ctx, "b.example.com")
+	assert.NoError(t, err)
+	_, err = core.startContainer(ctx, ct, startPrewarm)
+	assert.ErrorIs(t, err, ErrNoRoom)
+	assert.Equal(t, 0, host.stopCount("a"))
+	assert.Equal(t, 0, host.startCount("b"))
+	assert.False(t, core.IsStarted("b"))
+}
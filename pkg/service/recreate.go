@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/sirupsen/logrus"
+)
+
+// A spec (if any) that a missing container may be re-created from: any with `recreate`,
+// otherwise only of containers we removed ourselves (stopmethod=remove)
+func (s *Core) recreatable(spec *containers.Spec) *containers.Spec {
+	if spec == nil || config.Model.Recreate {
+		return spec
+	}
+	if method, _ := spec.Wrapper().Config("stopmethod"); strings.EqualFold(method, StopMethodRemove) {
+		return spec
+	}
+	return nil
+}
+
+// Capture the spec of any lazyload container we haven't seen yet, so it can
+// be re-created if removed
+func (s *Core) captureSpecsSync(ctx context.Context) {
+	cts, err := s.discovery.FindAllLazyload(ctx, true)
+	if err != nil {
+		logrus.Warnf("Error listing containers to capture specs: %v", err)
+		return
+	}
+
+	changed := false
+	for _, ct := range cts {
+		if s.specs.Has(ct.Name(), ct.ID) {
+			continue
+		}
+
+		info, err := s.client.ContainerInspect(ctx, ct.ID)
+		if err != nil {
+			ct.Log().Warnf("Unable to inspect to capture spec: %v", err)
+			continue
+		}
+
+		ct.Log().Debug("Captured spec")
+		s.specs.Put(containers.SpecFromInspect(info))
+		changed = true
+	}
+
+	if changed {
+		if err := s.specs.Save(); err != nil {
+			logrus.Warnf("Unable to save container specs: %v", err)
+		}
+	}
+}
+
+// Pull the image if needed, and create the container from the spec
+func (s *Core) recreateContainer(cts *ContainerState, spec *containers.Spec) (*containers.Wrapper, error) {
+	pullCtx, cancel := context.WithTimeout(context.Background(), config.Model.PullTimeout)
+	err := s.ensureImage(pullCtx, cts, spec.Image())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
+	defer cancel()
+
+	s.emit(cts, EventCreating, "Creating container %s", spec.Name)
+
+	networks := spec.NetworkNames()
+	var netConfig *network.NetworkingConfig
+	if len(networks) > 0 {
+		// Older APIs only accept a single network at creation
+		netConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				networks[0]: spec.Networks[networks[0]],
+			},
+		}
+	}
+
+	created, err := s.client.ContainerCreate(ctx, spec.Config, spec.HostConfig, netConfig, nil, spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range created.Warnings {
+		cts.log().Warnf("Creating container: %s", warning)
+	}
+
+	if len(networks) > 1 {
+		for _, name := range networks[1:] {
+			if err := s.client.NetworkConnect(ctx, name, created.ID, spec.Networks[name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	containers.Logger(created.ID, spec.Name).Info("Re-created container")
+
+	ct := spec.Wrapper()
+	ct.ID = created.ID
+	ct.State = "created"
+	return ct, nil
+}
+
+// Pull the image if it doesn't exist locally, reporting progress
+func (s *Core) ensureImage(ctx context.Context, cts *ContainerState, image string) error {
+	if _, _, err := s.client.ImageInspectWithRaw(ctx, image); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return err
+	}
+
+	s.emit(cts, EventPulling, "Pulling image %s", image)
+
+	stream, err := s.client.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	const reportInterval = time.Second
+	var lastReport time.Time
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+
+		if time.Since(lastReport) >= reportInterval && msg.Status != "" {
+			lastReport = time.Now()
+			if msg.Progress != nil && msg.Progress.Total > 0 {
+				s.emit(cts, EventPulling, "%s %s: %d%%", msg.Status, msg.ID, msg.Progress.Current*100/msg.Progress.Total)
+			} else {
+				s.emit(cts, EventPulling, "%s %s", msg.Status, msg.ID)
+			}
+		}
+	}
+
+	cts.log().Infof("Pulled image %s", image)
+	return nil
+}
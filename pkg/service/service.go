@@ -4,29 +4,56 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 	"traefik-lazyload/pkg/config"
 	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/coordination"
+	"traefik-lazyload/pkg/logging"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 )
 
+// Core manages the lifecycle of lazyload containers
+//
+// Locking: `mux` only guards the container maps, and is never held across docker
+// calls. Each ContainerState has its own lock for its runtime state. `depMux`
+// serializes starting and stopping dependency providers, so a provider isn't stopped
+// out from under a container that is starting
 type Core struct {
-	mux  sync.Mutex
-	term chan bool
+	mux       sync.Mutex
+	depMux    sync.Mutex
+	term      chan struct{}
+	closeOnce sync.Once
 
 	client    containers.Host
 	discovery *containers.Discovery
-
-	active map[string]*ContainerState // cid -> state
+	elector   coordination.Elector // nil if running alone
+	leader    bool                 // guarded by mux
+
+	active     map[string]*ContainerState // cid -> state
+	recreating map[string]*ContainerState // name -> state, for containers being re-created from spec
+	events     *eventBus
+	specs      *containers.SpecStore
+	latency    *latencyTracker
+	memory     *memoryTracker
+	activity   *activityTracker
+	adaptive   *adaptiveTracker
+	prewarm    *prewarmTracker
+	queue      []queuedStart          // starts waiting for room under the running limits
+	startTimes map[string][]time.Time // name -> recent starts, for rate limiting
+	pins       map[string]bool        // name -> pinned at runtime
+	flaps      map[string][]time.Time // name -> starts within flapwindow
 }
 
-func New(client *client.Client, discovery *containers.Discovery, pollRate time.Duration) (*Core, error) {
+// Create a new core. If elector is non-nil, only the leader stops idle containers, while
+// all instances can start them
+func New(client containers.Host, discovery *containers.Discovery, elector coordination.Elector, pollRate time.Duration) (*Core, error) {
 	// Test client and report
 	if info, err := client.Info(context.Background()); err != nil {
 		return nil, err
@@ -34,12 +61,40 @@ func New(client *client.Client, discovery *containers.Discovery, pollRate time.D
 		logrus.Infof("Connected docker to %s (v%s)", info.Name, info.ServerVersion)
 	}
 
+	budget, dflt, err := parseMemoryConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	// Make core
 	ret := &Core{
-		client:    client,
-		discovery: discovery,
-		active:    make(map[string]*ContainerState),
-		term:      make(chan bool),
+		client:     client,
+		discovery:  discovery,
+		elector:    elector,
+		leader:     elector == nil,
+		active:     make(map[string]*ContainerState),
+		recreating: make(map[string]*ContainerState),
+		events:     newEventBus(),
+		latency:    newLatencyTracker(config.Model.StartHistory),
+		memory:     newMemoryTracker(budget, dflt),
+		adaptive:   newAdaptiveTracker(config.Model.StopDelayMin, config.Model.StopDelayMax),
+		startTimes: make(map[string][]time.Time),
+		pins:       make(map[string]bool),
+		flaps:      make(map[string][]time.Time),
+		term:       make(chan struct{}),
+	}
+
+	if ret.activity, err = newActivityTracker(config.Model.ActivityHistory, config.Model.ActivityFile); err != nil {
+		return nil, err
+	}
+
+	if ret.prewarm, err = newPrewarmTracker(config.Model.PrewarmFile, config.Model.PrewarmThreshold); err != nil {
+		return nil, err
+	}
+
+	// Loaded even without re-creation, for the containers we removed (see recreatable)
+	if ret.specs, err = containers.NewSpecStore(config.Model.SpecFile); err != nil {
+		return nil, err
 	}
 
 	ret.Poll() // initial force-poll to update
@@ -49,121 +104,516 @@ func New(client *client.Client, discovery *containers.Discovery, pollRate time.D
 }
 
 func (s *Core) Close() error {
-	s.mux.Lock()
-	defer s.mux.Unlock()
-
-	s.term <- true
+	s.closeOnce.Do(func() {
+		close(s.term)
+		if s.elector != nil {
+			s.elector.Close()
+		}
+		if err := s.activity.save(true); err != nil {
+			logrus.Warnf("Unable to save activity history: %v", err)
+		}
+	})
 	return s.client.Close()
 }
 
-func (s *Core) StartHost(hostname string) (*ContainerState, error) {
+// Start the container that serves the given http hostname. The context is only used
+// for its request ID (see logging.WithRequestID)
+func (s *Core) StartHost(ctx context.Context, hostname string) (*ContainerState, error) {
+	return s.startBy(ctx, hostname, containers.MatchHost, true)
+}
+
+// Start the container that serves the given TLS SNI server name. A TLS passthrough can't
+// authorize a wake, so stopped containers with a `wake.auth` policy are refused
+func (s *Core) StartSNI(serverName string) (*ContainerState, error) {
+	return s.startBy(context.Background(), serverName, containers.MatchSNI, false)
+}
+
+func (s *Core) startBy(reqCtx context.Context, hostname string, matcher containers.HostMatcher, authorized bool) (*ContainerState, error) {
+	ctx, cancel := context.WithTimeout(logging.WithRequestID(context.Background(), logging.RequestID(reqCtx)), config.Model.Timeout)
+	defer cancel()
+
+	ct, err := s.findBy(ctx, hostname, matcher)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			logging.FieldHost:      hostname,
+			logging.FieldRequestID: logging.RequestID(ctx),
+		}).Warnf("Unable to find container for host: %s", err)
+		return nil, err
+	}
+
+	if !authorized && s.needsWakeAuth(ct) {
+		return nil, ErrWakeAuthRequired
+	}
+	return s.startFound(ctx, hostname, ct)
+}
+
+// Start a container found by FindHost for a hostname, without looking it up again
+func (s *Core) StartFound(ctx context.Context, hostname string, ct *containers.Wrapper) (*ContainerState, error) {
+	ctx, cancel := context.WithTimeout(logging.WithRequestID(context.Background(), logging.RequestID(ctx)), config.Model.Timeout)
+	defer cancel()
+	return s.startFound(ctx, hostname, ct)
+}
+
+func (s *Core) startFound(ctx context.Context, hostname string, ct *containers.Wrapper) (*ContainerState, error) {
+	if ct.ID == "" { // wraps the spec of a missing container
+		spec := s.recreatable(s.specs.Get(ct.Name()))
+		if spec == nil {
+			return nil, containers.ErrNotFound
+		}
+		return s.woken(s.recreateHost(ctx, hostname, spec))
+	}
+
+	s.inspectMemoryLimit(ctx, ct.ID, ct.Name())
+	return s.woken(s.startContainer(ctx, ct, startRequested))
+}
+
+// Whether starting a container needs its `wake.auth` policy checked, ie. it has one and
+// isn't already started
+func (s *Core) needsWakeAuth(ct *containers.Wrapper) bool {
+	policy, _ := ct.Config("wake.auth")
+	return policy != "" && !s.IsStarted(ct.Name())
+}
+
+// Record a start requested by a client, for pre-warming predictions
+func (s *Core) woken(cts *ContainerState, err error) (*ContainerState, error) {
+	if err == nil {
+		s.prewarm.recordWake(cts.cname, time.Now())
+	}
+	return cts, err
+}
+
+// Find the container serving a hostname (or the captured spec of a missing one),
+// without starting it
+func (s *Core) FindHost(ctx context.Context, hostname string) (*containers.Wrapper, error) {
+	return s.findBy(ctx, hostname, containers.MatchHost)
+}
+
+func (s *Core) findBy(ctx context.Context, hostname string, matcher containers.HostMatcher) (*containers.Wrapper, error) {
+	ct, err := s.discovery.FindContainer(ctx, hostname, matcher)
+	if errors.Is(err, containers.ErrNotFound) {
+		if spec := s.recreatable(s.specs.Find(hostname, matcher)); spec != nil {
+			return spec.Wrapper(), nil
+		}
+	}
+	return ct, err
+}
+
+// True if a container (by name) is already on its way up, or running
+func (s *Core) IsStarted(name string) bool {
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	for _, cts := range s.allStatesLocked() {
+		if cts.cname != name {
+			continue
+		}
+		switch cts.Phase() {
+		case PhaseStopped, PhaseFailed:
+		default:
+			return true
+		}
+	}
+	return false
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
+// Start a container, unless already started (or queued behind the running limits).
+// Returns its state. The start is logged with the request ID of ctx, if any
+func (s *Core) startContainer(ctx context.Context, ct *containers.Wrapper, reason startReason) (*ContainerState, error) {
+	s.mux.Lock()
+	ets, exists := s.active[ct.ID]
+	if !exists {
+		ets = newStateFromContainer(ct, PhaseStopped)
+		s.active[ct.ID] = ets
+	}
+	requestID := logging.RequestID(ctx)
+	start := func() { s.launchStart(requestID, ets, ct) }
+	shouldStart, err := s.admitLocked(ets, reason, start)
+	if err != nil && !exists {
+		delete(s.active, ct.ID)
+	}
+	s.mux.Unlock()
 
-	ct, err := s.discovery.FindContainerByHostname(ctx, hostname)
 	if err != nil {
-		logrus.Warnf("Unable to find container for host %s: %s", hostname, err)
-		cancel()
 		return nil, err
 	}
-
-	if ets, exists := s.active[ct.ID]; exists {
-		logrus.Debugf("Asked to start host, but we already think it's started: %s", ets.name)
-		cancel()
+	if !shouldStart {
+		ets.log().Debugf("Asked to start, but it is already %s", ets.Phase())
 		return ets, nil
 	}
 
-	// add to active pool
-	logrus.Infof("Starting container for %s...", hostname)
-	ets := newStateFromContainer(ct)
-	s.active[ct.ID] = ets
-	ets.pinned = true // pin while starting
+	start()
+	return ets, nil
+}
+
+func (s *Core) launchStart(requestID string, ets *ContainerState, ct *containers.Wrapper) {
+	ets.setRequestID(requestID)
+	ets.log().Info("Starting container...")
 
 	go func() {
-		defer func() {
-			cancel()
-			s.mux.Lock()
-			ets.pinned = false
-			ets.lastActivity = time.Now()
-			s.mux.Unlock()
-		}()
-		s.startDependencyFor(ctx, ets.needs, ct.NameID())
-		s.startContainerSync(ctx, ct)
+		ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
+		defer cancel()
+		s.startContainerAndDependencies(ctx, ets, ct)
 	}()
+}
+
+// Re-create a missing container from its spec (pulling the image if needed) and start it
+func (s *Core) recreateHost(ctx context.Context, hostname string, spec *containers.Spec) (*ContainerState, error) {
+	s.mux.Lock()
+	if ets, exists := s.recreating[spec.Name]; exists {
+		s.mux.Unlock()
+		ets.log().Debug("Asked to start host, but we are already re-creating it")
+		return ets, nil
+	}
+
+	ets := newStateFromContainer(spec.Wrapper(), PhaseStopped)
+	if spec.HostConfig != nil {
+		s.memory.setLimit(spec.Name, spec.HostConfig.Memory)
+	}
+	s.recreating[spec.Name] = ets
+	requestID := logging.RequestID(ctx)
+	start := func() { s.launchRecreate(requestID, hostname, ets, spec) }
+	shouldStart, err := s.admitLocked(ets, startRequested, start)
+	if err != nil {
+		delete(s.recreating, spec.Name)
+	}
+	s.mux.Unlock()
 
+	if err != nil {
+		return nil, err
+	}
+	if shouldStart {
+		start()
+	}
 	return ets, nil
 }
 
+func (s *Core) launchRecreate(requestID, hostname string, ets *ContainerState, spec *containers.Spec) {
+	ets.setRequestID(requestID)
+	ets.log().WithField(logging.FieldHost, hostname).Info("Container for host is missing, re-creating...")
+
+	go func() {
+		ct, err := s.recreateContainer(ets, spec)
+		if err == nil {
+			ets.mux.Lock()
+			ets.id = ct.ID
+			ets.name = ct.NameID()
+			ets.mux.Unlock()
+		}
+
+		s.mux.Lock()
+		delete(s.recreating, spec.Name)
+		if err == nil {
+			s.active[ct.ID] = ets
+		}
+		s.mux.Unlock()
+
+		if err != nil {
+			ets.transition(PhaseFailed)
+			s.emit(ets, EventFailed, "Unable to re-create container: %v", err)
+			s.admitQueued()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
+		defer cancel()
+		s.startContainerAndDependencies(ctx, ets, ct)
+	}()
+}
+
+// Subscribe to lifecycle events of a container (or all containers if name is empty).
+// Returns the events of the container's most recent start, followed by a channel of
+// live events. The returned func must be called to unsubscribe
+func (s *Core) Subscribe(name string) ([]Event, <-chan Event, func()) {
+	return s.events.subscribe(name)
+}
+
+// Publish a lifecycle event of a container, and log it
+func (s *Core) emit(cts *ContainerState, evType EventType, format string, args ...interface{}) {
+	ev := Event{
+		Time:      time.Now(),
+		Type:      evType,
+		ID:        cts.ID(),
+		Name:      cts.cname,
+		Container: cts.Name(),
+		Message:   fmt.Sprintf(format, args...),
+	}
+
+	log := cts.log().WithField(logging.FieldEvent, string(evType))
+	switch evType {
+	case EventWarning, EventFailed, EventFlapping:
+		log.Warn(ev.Message)
+	default:
+		log.Info(ev.Message)
+	}
+
+	s.events.publish(ev)
+}
+
+func (s *Core) startContainerAndDependencies(ctx context.Context, cts *ContainerState, ct *containers.Wrapper) {
+	s.emit(cts, EventResolving, "Resolving dependencies")
+	if err := s.startDependencyFor(ctx, cts, cts.needs); err != nil {
+		s.emit(cts, EventWarning, "Error starting dependencies: %v", err)
+	}
+
+	if err := s.runHook(ctx, cts, HookPreStart); err != nil {
+		cts.transition(PhaseFailed)
+		s.emit(cts, EventFailed, "Not starting container: %v", err)
+		s.admitQueued()
+		return
+	}
+	if _, ok := cts.hooks[HookPreStart]; ok {
+		// The hook may have taken most of the timeout
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(logging.WithRequestID(context.Background(), logging.RequestID(ctx)), config.Model.Timeout)
+		defer cancel()
+	}
+
+	s.emit(cts, EventStarting, "Starting container")
+	if err := s.startContainerSync(ctx, ct); err != nil {
+		cts.transition(PhaseFailed)
+		s.emit(cts, EventFailed, "Error starting container: %v", err)
+		s.admitQueued()
+		return
+	}
+	requested := cts.requestedAt()
+	toRunning := time.Since(requested)
+
+	if err := cts.transition(PhaseWaitingReady); err != nil {
+		cts.log().Debugf("Not waiting for container: %v", err) // eg. stopped while starting
+		return
+	}
+	s.emit(cts, EventWaiting, "Waiting for container to be healthy")
+	if err := s.waitForHealthy(ctx, ct.ID); err != nil {
+		cts.transition(PhaseFailed)
+		s.emit(cts, EventFailed, "Container did not become healthy: %v", err)
+		s.admitQueued()
+		return
+	}
+
+	if err := cts.transition(PhaseRunning); err != nil {
+		cts.log().Debugf("Not marking container ready: %v", err)
+		return
+	}
+	toReady := time.Since(requested)
+	s.latency.record(cts.cname, StartSample{requested, toRunning, toReady})
+	cts.log().WithField(logging.FieldDuration, toReady.Seconds()).Debugf("Cold start: running after %s, ready after %s", toRunning, toReady)
+	s.emit(cts, EventReady, "Container is ready")
+
+	if err := s.runHook(ctx, cts, HookPostStart); err != nil {
+		s.emit(cts, EventWarning, "%v", err)
+	}
+}
+
+// Cold-start latency summary of a container, by name
+func (s *Core) StartStats(name string) StartStats {
+	return s.latency.stats(name)
+}
+
+// Cold-start latency summaries of all containers that have been started, by name
+func (s *Core) AllStartStats() map[string]StartStats {
+	return s.latency.all()
+}
+
+// Estimated time until a starting container is ready, based on its history. Returns
+// false if there is no history to base it on
+func (s *Core) EstimatedWait(cts *ContainerState) (time.Duration, bool) {
+	stats := s.latency.stats(cts.cname)
+	if stats.Count == 0 {
+		return 0, false
+	}
+	remaining := stats.ReadyP50 - time.Since(cts.Started())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// Waits for the container's healthcheck to pass. Containers without healthchecks
+// are considered ready as soon as they are running
+func (s *Core) waitForHealthy(ctx context.Context, cid string) error {
+	const checkInterval = 500 * time.Millisecond
+	for {
+		info, err := s.client.ContainerInspect(ctx, cid)
+		if err != nil {
+			return err
+		}
+		if info.State == nil || !info.State.Running {
+			return ErrNotRunning
+		}
+		if info.State.Health == nil {
+			return nil
+		}
+		switch info.State.Health.Status {
+		case types.Healthy:
+			return nil
+		case types.Unhealthy:
+			return ErrUnhealthy
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// Resolve the network address (ip:port) of a running container's TCP backend
+func (s *Core) ContainerAddress(ctx context.Context, cts *ContainerState) (string, error) {
+	if cts.tcpPort <= 0 {
+		return "", ErrNoPort
+	}
+
+	info, err := s.client.ContainerInspect(ctx, cts.ID())
+	if err != nil {
+		return "", err
+	}
+	if info.State == nil || !info.State.Running {
+		return "", ErrNotRunning
+	}
+
+	if info.NetworkSettings != nil {
+		for _, network := range info.NetworkSettings.Networks {
+			if network.IPAddress != "" {
+				return net.JoinHostPort(network.IPAddress, strconv.Itoa(cts.tcpPort)), nil
+			}
+		}
+	}
+	return "", ErrNoAddress
+}
+
 // Stop all running containers pined with the configured label
 func (s *Core) StopAll() {
-	s.mux.Lock()
-	defer s.mux.Unlock()
-
 	ctx := context.Background()
 
 	logrus.Info("Stopping all containers...")
-	for cid, ct := range s.active {
-		logrus.Infof("Stopping %s...", ct.name)
-		if err := s.client.ContainerStop(ctx, cid, container.StopOptions{}); err != nil {
-			logrus.Warnf("Error stopping %s: %v", ct.name, err)
+	s.forEachParallel(s.ActiveContainers(), func(cts *ContainerState) {
+		if !cts.beginStop(true) {
+			return
+		}
+		cts.log().Info("Stopping container...")
+		if err := s.stopContainer(ctx, cts.ID(), &cts.stopSettings); err != nil {
+			cts.log().Warnf("Error stopping container: %v", err)
+			cts.abortStop()
 		} else {
-			delete(s.active, cid)
+			s.finishStop(cts)
 		}
-	}
+	})
+	s.admitQueued()
 }
 
 // Returns all actively managed containers
 func (s *Core) ActiveContainers() []*ContainerState {
 	s.mux.Lock()
-	defer s.mux.Unlock()
-
 	ret := make([]*ContainerState, 0, len(s.active))
 	for _, item := range s.active {
 		ret = append(ret, item)
 	}
+	s.mux.Unlock()
+
 	sort.Slice(ret, func(i, j int) bool {
-		return ret[i].name < ret[j].name
+		return ret[i].Name() < ret[j].Name()
 	})
 	return ret
 }
 
+// Mark a container as stopped and remove it from the active pool (if it is still the
+// one tracked for its container). Returns true if a restart was requested while stopping
+func (s *Core) finishStop(cts *ContainerState) (restart bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	restart = cts.completeStop()
+	s.activity.reset(cts.cname)
+	cid := cts.ID()
+	if s.active[cid] == cts {
+		delete(s.active, cid)
+	}
+	return
+}
+
+// Start a container again, after it was stopped
+func (s *Core) restart(cts *ContainerState) {
+	cts.log().Info("Restarting, requested while stopping")
+	var err error
+	if cts.stopMethod == StopMethodRemove {
+		if spec := s.specs.Get(cts.cname); spec != nil {
+			_, err = s.recreateHost(context.Background(), cts.cname, spec)
+		}
+	} else {
+		_, err = s.startContainer(context.Background(), cts.wrapper(), startRequested)
+	}
+	if err != nil {
+		cts.log().Warnf("Unable to restart: %v", err)
+	}
+}
+
+// Run fn for each state, with bounded parallelism, and wait for all to finish
+func (s *Core) forEachParallel(states []*ContainerState, fn func(cts *ContainerState)) {
+	parallelism := config.Model.PollParallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for _, cts := range states {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(cts *ContainerState) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(cts)
+		}(cts)
+	}
+	wg.Wait()
+}
+
+// Start (or resume) a container, based on its current state in docker
 func (s *Core) startContainerSync(ctx context.Context, ct *containers.Wrapper) error {
+	// The listed state may be stale (eg. stopped since), so check again
+	if info, err := s.client.ContainerInspect(ctx, ct.ID); err == nil && info.State != nil {
+		ct.State = info.State.Status
+	}
 	if ct.IsRunning() {
 		return nil
 	}
 
-	if err := s.client.ContainerStart(ctx, ct.ID, types.ContainerStartOptions{}); err != nil {
-		logrus.Warnf("Error starting container %s: %s", ct.NameID(), err)
+	started := time.Now()
+	if err := s.resumeContainer(ctx, ct); err != nil {
+		ct.Log().Warnf("Error starting container: %s", err)
 		return err
 	} else {
-		logrus.Infof("Started container %s", ct.NameID())
+		ct.Log().WithField(logging.FieldDuration, time.Since(started).Seconds()).Info("Started container")
 	}
 	return nil
 }
 
-func (s *Core) startDependencyFor(ctx context.Context, needs []string, forContainer string) error {
+func (s *Core) startDependencyFor(ctx context.Context, cts *ContainerState, needs []string) error {
+	s.depMux.Lock()
+	defer s.depMux.Unlock()
+
 	for _, dep := range needs {
 		providers, err := s.discovery.FindDepProvider(ctx, dep)
 
 		if err != nil {
-			logrus.Errorf("Error finding dependency provider for %s: %v", dep, err)
+			cts.log().Errorf("Error finding dependency provider for %s: %v", dep, err)
 			return err
 		} else if len(providers) == 0 {
-			logrus.Warnf("Unable to find any container that provides %s for %s", dep, forContainer)
+			cts.log().Warnf("Unable to find any container that provides %s", dep)
 			return ErrProviderNotFound
 		} else {
 			for _, provider := range providers {
 				if !provider.IsRunning() {
-					logrus.Infof("Starting dependency for %s: %s", forContainer, provider.NameID())
+					s.emit(cts, EventProvider, "Starting %s for %s", provider.NameID(), dep)
 
 					if err := s.startContainerSync(ctx, &provider); err != nil {
 						return err
 					}
 
 					delay, _ := provider.ConfigDuration("provides.delay", 2*time.Second)
-					logrus.Debugf("Delaying %s to start %s", delay.String(), dep)
+					provider.Log().Debugf("Delaying %s to start %s", delay.String(), dep)
 					time.Sleep(delay)
 				}
 			}
@@ -173,7 +623,16 @@ func (s *Core) startDependencyFor(ctx context.Context, needs []string, forContai
 	return nil
 }
 
-func (s *Core) stopDependenciesFor(ctx context.Context, cid string, cts *ContainerState) []error {
+// Stop the dependencies of a container (that has been removed from the active pool)
+// if no other active container needs them
+func (s *Core) stopDependenciesFor(ctx context.Context, cts *ContainerState) []error {
+	if len(cts.needs) == 0 {
+		return nil
+	}
+
+	s.depMux.Lock()
+	defer s.depMux.Unlock()
+
 	// Look at our needs, and see if anything else needs them; if not, shut down
 	var errs []error
 
@@ -182,29 +641,36 @@ func (s *Core) stopDependenciesFor(ctx context.Context, cid string, cts *Contain
 		deps[dep] = false
 	}
 
-	for activeId, active := range s.active {
-		if activeId != cid { // ignore self
-			for _, need := range active.needs {
-				deps[need] = true
-			}
+	s.mux.Lock()
+	for _, active := range s.active {
+		for _, need := range active.needs {
+			deps[need] = true
+		}
+	}
+	for _, recreating := range s.recreating {
+		for _, need := range recreating.needs {
+			deps[need] = true
 		}
 	}
+	s.mux.Unlock()
 
 	for dep, needed := range deps {
 		if !needed {
-			logrus.Infof("Stopping dependency %s...", dep)
+			log := cts.log().WithField(logging.FieldDependency, dep)
+			log.Info("Stopping dependency...")
 			containers, err := s.discovery.FindDepProvider(ctx, dep)
 			if err != nil {
-				logrus.Errorf("Unable to find dependency provider containers for %s: %v", dep, err)
+				log.Errorf("Unable to find dependency provider containers: %v", err)
 				errs = append(errs, err)
 			} else if len(containers) == 0 {
-				logrus.Warnf("Unable to find any containers for dependency %s", dep)
+				log.Warn("Unable to find any containers for dependency")
 			} else {
 				for _, ct := range containers {
 					if ct.IsRunning() {
-						logrus.Infof("Stopping %s...", ct.NameID())
-						if err := s.client.ContainerStop(ctx, ct.ID, container.StopOptions{}); err != nil {
-							logrus.Warnf("Error stopping %s: %v", ct.NameID(), err)
+						ct.Log().Info("Stopping container...")
+						settings := extractStopSettings(&ct)
+						if err := s.stopContainer(ctx, ct.ID, &settings); err != nil {
+							ct.Log().Warnf("Error stopping container: %v", err)
 						}
 					}
 				}
@@ -237,11 +703,48 @@ func (s *Core) Poll() {
 	ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
 	defer cancel()
 
-	s.checkForNewContainersSync(ctx)
+	// Everyone keeps their view of running containers up to date, but only
+	// the leader stops them
+	leader := s.checkLeader(ctx)
+	s.checkForNewContainersSync(ctx, leader)
+	s.admitQueued() // room may have been made, eg. by the leader stopping containers
+	defer s.events.publish(Event{Time: time.Now(), Type: EventPoll})
+	if !leader {
+		return
+	}
+
 	s.watchForInactivitySync(ctx)
+	if config.Model.Recreate {
+		s.captureSpecsSync(ctx)
+	}
+	s.prewarmSync(ctx)
+	s.admitQueued()
+
+	if err := s.activity.save(false); err != nil {
+		logrus.Warnf("Unable to save activity history: %v", err)
+	}
 }
 
-func (s *Core) checkForNewContainersSync(ctx context.Context) {
+// True if this instance runs the stop loop (always, when running alone)
+func (s *Core) IsLeader() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.leader
+}
+
+func (s *Core) checkLeader(ctx context.Context) bool {
+	if s.elector == nil {
+		return true
+	}
+
+	leader := s.elector.IsLeader(ctx)
+	s.mux.Lock()
+	s.leader = leader
+	s.mux.Unlock()
+	return leader
+}
+
+func (s *Core) checkForNewContainersSync(ctx context.Context, stopDependencies bool) {
 	cts, err := s.discovery.FindAllLazyload(ctx, false)
 	if err != nil {
 		logrus.Warnf("Error checking for new containers: %v", err)
@@ -255,63 +758,86 @@ func (s *Core) checkForNewContainersSync(ctx context.Context) {
 		}
 	}
 
-	// Lock
-	s.mux.Lock()
-	defer s.mux.Unlock()
+	var removed []*ContainerState
 
+	s.mux.Lock()
 	// check for containers we think are running, but aren't (destroyed, error'd, stop'd via another process, etc)
 	for cid, cts := range s.active {
-		if _, ok := runningContainers[cid]; !ok && !cts.pinned {
-			logrus.Infof("Discover container had stopped, removing %s", cts.name)
+		if _, ok := runningContainers[cid]; !ok && cts.discoverStopped() {
+			cts.log().Info("Discovered container had stopped, removing")
 			delete(s.active, cid)
-			s.stopDependenciesFor(ctx, cid, cts)
+			removed = append(removed, cts)
 		}
 	}
 
 	// now, look for containers that are running, but aren't in our active inventory
 	for _, ct := range runningContainers {
 		if _, ok := s.active[ct.ID]; !ok {
-			logrus.Infof("Discovered running container %s", ct.NameID())
-			s.active[ct.ID] = newStateFromContainer(ct)
+			ct.Log().Info("Discovered running container")
+			s.active[ct.ID] = newStateFromContainer(ct, PhaseRunning)
+		}
+	}
+	s.mux.Unlock()
+
+	if stopDependencies {
+		for _, cts := range removed {
+			s.stopDependenciesFor(ctx, cts)
 		}
 	}
 }
 
+// Check all active containers for inactivity (concurrently), stopping those that are idle
 func (s *Core) watchForInactivitySync(ctx context.Context) {
-	s.mux.Lock()
-	defer s.mux.Unlock()
-
-	for cid, cts := range s.active {
-		shouldStop, err := s.checkContainerForInactivity(ctx, cid, cts)
+	s.forEachParallel(s.ActiveContainers(), func(cts *ContainerState) {
+		shouldStop, err := s.checkContainerForInactivity(ctx, cts)
 		if err != nil {
-			logrus.Warnf("error checking container state for %s: %s", cts.name, err)
+			cts.log().Warnf("Error checking container state: %s", err)
 		}
-		if shouldStop {
-			s.stopContainerAndDependencies(ctx, cid, cts)
+		if shouldStop && s.stopContainerAndDependencies(ctx, cts) == nil && cts.adaptiveDelay {
+			s.adaptive.idleStopped(cts.cname)
 		}
-	}
+	})
 }
 
-func (s *Core) stopContainerAndDependencies(ctx context.Context, cid string, cts *ContainerState) {
+// Stop a container, which must have been marked as stopping
+func (s *Core) stopContainerAndDependencies(ctx context.Context, cts *ContainerState) error {
+	if err := s.runHook(ctx, cts, HookPreStop); err != nil {
+		s.emit(cts, EventWarning, "%v", err)
+	}
+
 	// First, stop the host container
-	if err := s.client.ContainerStop(ctx, cid, container.StopOptions{}); err != nil {
-		logrus.Errorf("Error stopping container %s: %s", cts.name, err)
+	started := time.Now()
+	if err := s.stopContainer(ctx, cts.ID(), &cts.stopSettings); err != nil {
+		cts.log().Errorf("Error stopping container: %s", err)
+		cts.abortStop()
+		return err
+	}
+
+	cts.log().WithField(logging.FieldDuration, time.Since(started).Seconds()).Debug("Stopped container")
+	s.emit(cts, EventStopped, "Container stopped")
+	if err := s.runHook(ctx, cts, HookPostStop); err != nil {
+		s.emit(cts, EventWarning, "%v", err)
+	}
+	if s.finishStop(cts) {
+		s.restart(cts) // dependencies are still needed
 	} else {
-		logrus.Infof("Stopped container %s", cts.name)
-		delete(s.active, cid)
-		s.stopDependenciesFor(ctx, cid, cts)
+		s.stopDependenciesFor(ctx, cts)
 	}
+	s.admitQueued()
+	return nil
 }
 
-func (s *Core) checkContainerForInactivity(ctx context.Context, cid string, ct *ContainerState) (shouldStop bool, retErr error) {
-	if ct.pinned {
+// Checks network activity of a running container. If it should be stopped, it is marked as stopping
+func (s *Core) checkContainerForInactivity(ctx context.Context, ct *ContainerState) (shouldStop bool, retErr error) {
+	if phase := ct.Phase(); phase != PhaseRunning && phase != PhaseIdle {
 		return false, nil
 	}
 
-	statsStream, err := s.client.ContainerStatsOneShot(ctx, cid)
+	statsStream, err := s.client.ContainerStatsOneShot(ctx, ct.ID())
 	if err != nil {
 		return false, err
 	}
+	defer statsStream.Body.Close()
 
 	var stats types.StatsJSON
 	if err := json.NewDecoder(statsStream.Body).Decode(&stats); err != nil {
@@ -320,23 +846,24 @@ func (s *Core) checkContainerForInactivity(ctx context.Context, cid string, ct *
 
 	if stats.PidsStats.Current == 0 {
 		// Probably stopped. Will let next poll update container
-		return true, errors.New("container not running")
+		return ct.beginStop(false), errors.New("container not running")
 	}
 
+	s.memory.observe(ct.cname, statsMemoryUsage(&stats))
+	s.activity.observe(ct.cname, ct.ID(), &stats)
+	s.inspectMemoryLimit(ctx, ct.ID(), ct.cname)
+
 	// check for network activity
 	rx, tx := sumNetworkBytes(stats.Networks)
-	if rx > ct.lastRecv || tx > ct.lastSend {
-		ct.lastRecv = rx
-		ct.lastSend = tx
-		ct.lastActivity = time.Now()
-		return false, nil
+	active, idle := ct.observeTraffic(rx, tx, s.StopDelay(ct))
+	if active {
+		s.prewarm.recordUse(ct.cname, time.Now(), config.Model.PrewarmLead)
 	}
 
 	// No activity, stop?
-	if time.Now().After(ct.lastActivity.Add(ct.stopDelay)) {
-		logrus.Infof("Found idle container %s...", ct.name)
-		return true, nil
+	if !idle || s.IsPinned(ct) || !s.drained(ctx, ct) {
+		return false, nil
 	}
-
-	return false, nil
+	ct.log().Info("Found idle container...")
+	return ct.beginIdleStop(), nil
 }
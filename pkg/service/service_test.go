@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+	"traefik-lazyload/pkg/logging"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestConfig() {
+	config.Model.LabelPrefix = "lazyloader"
+	config.Model.Timeout = 5 * time.Second
+	config.Model.StopDelay = time.Hour
+	config.Model.DrainMax = time.Minute
+	config.Model.PollParallelism = 4
+	config.Model.MaxRunning = 0
+	config.Model.GroupLimits = nil
+	config.Model.LimitMode = ""
+	config.Model.StartsPerMinute = 0
+	config.Model.MemoryBudget = ""
+	config.Model.MemoryDefault = ""
+	config.Model.ActivityHistory = 0
+	config.Model.ActivityFile = ""
+	config.Model.AdaptiveDelay = false
+	config.Model.StopDelayMin = 0
+	config.Model.StopDelayMax = 0
+	config.Model.PrewarmLead = 0
+	config.Model.PrewarmThreshold = 0.5
+	config.Model.PrewarmFile = ""
+	config.Model.FlapStarts = 0
+	config.Model.Recreate = false
+	config.Model.CheckpointDir = ""
+}
+
+func newTestCore(t *testing.T, host *mockHost) *Core {
+	setupTestConfig()
+	core, err := New(host, containers.NewDiscovery(host), nil, time.Hour)
+	assert.NoError(t, err)
+	t.Cleanup(func() { core.Close() })
+	return core
+}
+
+func lazyLabels(host string, extra ...string) map[string]string {
+	labels := map[string]string{
+		"lazyloader":       "true",
+		"lazyloader.hosts": host,
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels[extra[i]] = extra[i+1]
+	}
+	return labels
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStartHostDeduplicates(t *testing.T) {
+	host := newMockHost()
+	host.startDelay = 50 * time.Millisecond
+	host.add("a", "app", "exited", lazyLabels("a.example.com"))
+	core := newTestCore(t, host)
+
+	var wg sync.WaitGroup
+	states := make([]*ContainerState, 50)
+	for i := range states {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cts, err := core.StartHost(context.Background(), "a.example.com")
+			assert.NoError(t, err)
+			states[i] = cts
+		}(i)
+	}
+	wg.Wait()
+
+	for _, cts := range states {
+		assert.Same(t, states[0], cts)
+	}
+	waitFor(t, func() bool { return !states[0].isBusy() })
+	assert.Equal(t, 1, host.startCount("a"))
+}
+
+func TestSlowStopDoesNotBlockStart(t *testing.T) {
+	host := newMockHost()
+	host.stopDelay = 500 * time.Millisecond
+	host.add("b", "b", "exited", lazyLabels("b.example.com"))
+	core := newTestCore(t, host)
+	host.add("idle", "idle", "running", lazyLabels("idle.example.com", "lazyloader.stopdelay", "0s"))
+
+	pollDone := make(chan struct{})
+	go func() {
+		core.Poll()
+		close(pollDone)
+	}()
+	waitFor(t, func() bool {
+		active := core.ActiveContainers()
+		return len(active) == 1 && active[0].isBusy()
+	})
+
+	started := time.Now()
+	_, err := core.StartHost(context.Background(), "b.example.com")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(started), 250*time.Millisecond)
+	assert.Len(t, core.ActiveContainers(), 2)
+
+	<-pollDone
+	assert.Equal(t, 1, host.stopCount("idle"))
+	waitFor(t, func() bool { return host.startCount("b") == 1 })
+}
+
+func TestConcurrentLoad(t *testing.T) {
+	host := newMockHost()
+	const hosts = 10
+	for i := 0; i < hosts; i++ {
+		id := fmt.Sprintf("c%d", i)
+		host.add(id, id, "exited", lazyLabels(id+".example.com", "lazyloader.stopdelay", "0s"))
+	}
+	core := newTestCore(t, host)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// Pollers, stopping idle containers as fast as they can
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					core.Poll()
+				}
+			}
+		}()
+	}
+
+	// Readers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				for _, cts := range core.ActiveContainers() {
+					cts.Name()
+					cts.LastActiveAge()
+					cts.Rx()
+				}
+			}
+		}
+	}()
+
+	// Requests
+	var reqs sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		reqs.Add(1)
+		go func(i int) {
+			defer reqs.Done()
+			_, err := core.StartHost(context.Background(), fmt.Sprintf("c%d.example.com", i%hosts))
+			assert.NoError(t, err)
+		}(i)
+	}
+	reqs.Wait()
+	close(stop)
+	wg.Wait()
+
+	// Every container was started at least once, and never more than it was requested
+	for i := 0; i < hosts; i++ {
+		id := fmt.Sprintf("c%d", i)
+		waitFor(t, func() bool { return host.startCount(id) >= 1 })
+		assert.LessOrEqual(t, host.startCount(id), 200/hosts)
+	}
+}
+
+func TestStartLogsRequestID(t *testing.T) {
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com"))
+	core := newTestCore(t, host)
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	cts, err := core.StartHost(ctx, "a.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+
+	var ready *logrus.Entry
+	waitFor(t, func() bool {
+		for _, entry := range hook.AllEntries() {
+			if entry.Data[logging.FieldEvent] == string(EventReady) {
+				ready = entry
+				return true
+			}
+		}
+		return false
+	})
+	assert.Equal(t, "req-1", ready.Data[logging.FieldRequestID])
+	assert.Equal(t, "app", ready.Data[logging.FieldContainerName])
+}
+
+func TestStopDependencyLogsFields(t *testing.T) {
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	host := newMockHost()
+	host.add("d", "db", "running", map[string]string{"lazyloader.provides": "db"})
+	host.add("a", "app", "exited", lazyLabels("a.example.com", "lazyloader.needs", "db"))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+
+	startStopped(t, core, "app")
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	waitFor(t, func() bool { return host.stopCount("d") == 1 })
+
+	var stopping *logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Stopping dependency..." {
+			stopping = entry
+		}
+	}
+	if assert.NotNil(t, stopping) {
+		assert.Equal(t, "db", stopping.Data[logging.FieldDependency])
+		assert.Equal(t, "app", stopping.Data[logging.FieldContainerName])
+	}
+}
+
+func TestStartSNIRefusesWakeAuth(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com", "lazyloader.wake.auth", "click"))
+	core := newTestCore(t, host)
+
+	_, err := core.StartSNI("a.example.com")
+	assert.ErrorIs(t, err, ErrWakeAuthRequired)
+	assert.Equal(t, 0, host.startCount("a"))
+
+	// Once started by an authorized request, passthrough connections are let through
+	_, err = core.StartHost(context.Background(), "a.example.com")
+	assert.NoError(t, err)
+	cts, err := core.StartSNI("a.example.com")
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+	assert.Equal(t, 1, host.startCount("a"))
+}
+
+func TestSpecsOnlyUsedForRemovedWithoutRecreate(t *testing.T) {
+	host := newMockHost()
+	core := newTestCore(t, host)
+	core.specs.Put(&containers.Spec{Name: "old", Config: &container.Config{Labels: lazyLabels("old.example.com")}})
+	core.specs.Put(&containers.Spec{Name: "gone", Config: &container.Config{Labels: lazyLabels("gone.example.com", "lazyloader.stopmethod", "remove")}})
+	ctx := context.Background()
+
+	_, err := core.FindHost(ctx, "old.example.com")
+	assert.ErrorIs(t, err, containers.ErrNotFound)
+	ct, err := core.FindHost(ctx, "gone.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "gone", ct.Name())
+
+	config.Model.Recreate = true
+	_, err = core.FindHost(ctx, "old.example.com")
+	assert.NoError(t, err)
+}
+
+func TestStartFoundDoesNotListAgain(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "exited", lazyLabels("a.example.com"))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+
+	ct, err := core.FindHost(ctx, "a.example.com")
+	assert.NoError(t, err)
+	host.mux.Lock()
+	lists := host.lists
+	host.mux.Unlock()
+
+	cts, err := core.StartFound(ctx, "a.example.com", ct)
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+	host.mux.Lock()
+	assert.Equal(t, lists, host.lists)
+	host.mux.Unlock()
+	assert.Equal(t, 1, host.startCount("a"))
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StopMethodStop   = "stop"   // Graceful stop, with optional signal and timeout
+	StopMethodPause  = "pause"  // Freeze the processes; resumes near-instantly with unpause
+	StopMethodKill   = "kill"   // Send a signal (SIGKILL by default) without waiting
+	StopMethodRemove = "remove" // Stop and remove; re-created from spec on demand
+)
+
+type stopSettings struct {
+	stopMethod  string
+	stopSignal  string
+	stopTimeout time.Duration // <0 for daemon default
+}
+
+func extractStopSettings(ct *containers.Wrapper) (target stopSettings) {
+	target.stopMethod, _ = ct.ConfigOrDefault("stopmethod", StopMethodStop)
+	target.stopMethod = strings.ToLower(target.stopMethod)
+	target.stopSignal, _ = ct.ConfigOrDefault("stopsignal", "")
+	target.stopTimeout, _ = ct.ConfigDuration("stoptimeout", -1)
+
+	switch target.stopMethod {
+	case StopMethodStop, StopMethodPause, StopMethodKill, StopMethodRemove, StopMethodCheckpoint:
+	default:
+		ct.Log().Warnf("Unknown stop method %s, using %s", target.stopMethod, StopMethodStop)
+		target.stopMethod = StopMethodStop
+	}
+	return
+}
+
+func (s *stopSettings) StopMethod() string {
+	return s.stopMethod
+}
+
+func (s *stopSettings) stopOptions() (opts container.StopOptions) {
+	opts.Signal = s.stopSignal
+	if s.stopTimeout >= 0 {
+		seconds := int(s.stopTimeout.Seconds())
+		opts.Timeout = &seconds
+	}
+	return
+}
+
+// Stop a container using its configured stop method
+func (s *Core) stopContainer(ctx context.Context, cid string, settings *stopSettings) error {
+	switch settings.stopMethod {
+	case StopMethodPause:
+		return s.client.ContainerPause(ctx, cid)
+	case StopMethodCheckpoint:
+		return s.checkpointContainer(ctx, cid, settings)
+	case StopMethodKill:
+		signal := settings.stopSignal
+		if signal == "" {
+			signal = "SIGKILL"
+		}
+		return s.client.ContainerKill(ctx, cid, signal)
+	case StopMethodRemove:
+		// Make sure we can bring it back
+		info, err := s.client.ContainerInspect(ctx, cid)
+		if err != nil {
+			return err
+		}
+		s.specs.Put(containers.SpecFromInspect(info))
+		if err := s.specs.Save(); err != nil {
+			logrus.Warnf("Unable to save container specs: %v", err)
+		}
+
+		if err := s.client.ContainerStop(ctx, cid, settings.stopOptions()); err != nil {
+			return err
+		}
+		err = s.client.ContainerRemove(ctx, cid, types.ContainerRemoveOptions{})
+		if client.IsErrNotFound(err) || (errdefs.IsConflict(err) && strings.Contains(err.Error(), "already in progress")) {
+			return nil // Docker removes it itself (eg. with AutoRemove)
+		}
+		return err
+	default:
+		return s.client.ContainerStop(ctx, cid, settings.stopOptions())
+	}
+}
+
+// Start or resume a container, depending on its current state
+func (s *Core) resumeContainer(ctx context.Context, ct *containers.Wrapper) error {
+	if ct.IsPaused() {
+		return s.client.ContainerUnpause(ctx, ct.ID)
+	}
+	if method, _ := ct.Config("stopmethod"); strings.EqualFold(method, StopMethodCheckpoint) {
+		return s.restoreContainer(ctx, ct)
+	}
+	return s.client.ContainerStart(ctx, ct.ID, types.ContainerStartOptions{})
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startStopped(t *testing.T, core *Core, name string) *ContainerState {
+	cts, err := core.StartByName(context.Background(), name)
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return cts.Phase() == PhaseRunning })
+	return cts
+}
+
+func TestStopMethodPause(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "running", lazyLabels("a.example.com", "lazyloader.stopmethod", "pause"))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+	core.Poll()
+
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	assert.Equal(t, 1, host.opCount("pause", "a"))
+	assert.Equal(t, 0, host.stopCount("a"))
+
+	startStopped(t, core, "app")
+	assert.Equal(t, 1, host.opCount("unpause", "a"))
+	assert.Equal(t, 0, host.startCount("a"))
+}
+
+func TestStopMethodKill(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "running", lazyLabels("a.example.com", "lazyloader.stopmethod", "kill"))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+	core.Poll()
+
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	assert.Equal(t, 1, host.opCount("kill", "a:SIGKILL"))
+	assert.Equal(t, 0, host.stopCount("a"))
+
+	startStopped(t, core, "app")
+	assert.Equal(t, 1, host.startCount("a"))
+}
+
+func TestStopMethodRemove(t *testing.T) {
+	host := newMockHost()
+	host.add("a", "app", "running", lazyLabels("a.example.com", "lazyloader.stopmethod", "remove"))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+	core.Poll()
+
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	assert.Equal(t, 1, host.stopCount("a"))
+	assert.Equal(t, 1, host.opCount("remove", "a"))
+	assert.False(t, host.exists("a"))
+
+	// Re-created from the saved spec, with its labels
+	startStopped(t, core, "app")
+	assert.Equal(t, 1, host.opCount("create", "app"))
+	assert.Equal(t, 1, host.startCount("app-1"))
+
+	ct, err := core.FindHost(ctx, "a.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "app-1", ct.ID)
+}
+
+func TestStopMethodRemoveAutoRemoved(t *testing.T) {
+	host := newMockHost()
+	host.autoRemove = true
+	host.add("a", "app", "running", lazyLabels("a.example.com", "lazyloader.stopmethod", "remove"))
+	core := newTestCore(t, host)
+	ctx := context.Background()
+	core.Poll()
+	core.mux.Lock()
+	cts := core.stateByNameLocked("app")
+	core.mux.Unlock()
+
+	// Docker removed it on stop, so removing it again finds nothing
+	assert.NoError(t, core.StopByName(ctx, "app"))
+	assert.Equal(t, 1, host.opCount("remove", "a"))
+	assert.False(t, host.exists("a"))
+	assert.Equal(t, PhaseStopped, cts.Phase())
+
+	startStopped(t, core, "app")
+	assert.Equal(t, 1, host.opCount("create", "app"))
+}
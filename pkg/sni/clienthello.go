@@ -0,0 +1,45 @@
+package sni
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+var errHelloRead = errors.New("client hello read")
+
+// Reads the TLS ClientHello from r without terminating the TLS session. Returns
+// the requested server name, and a reader that replays all bytes consumed so far
+func peekClientHello(r io.Reader) (serverName string, peeked io.Reader, err error) {
+	var buf bytes.Buffer
+	var hello *tls.ClientHelloInfo
+
+	err = tls.Server(readOnlyConn{io.TeeReader(r, &buf)}, &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = chi
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	if hello == nil {
+		return "", nil, err
+	}
+	return hello.ServerName, &buf, nil
+}
+
+// net.Conn that can only be read from, used to feed a tls.Server that should never respond
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (s readOnlyConn) Read(p []byte) (int, error)         { return s.r.Read(p) }
+func (s readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (s readOnlyConn) Close() error                       { return nil }
+func (s readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (s readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (s readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (s readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (s readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
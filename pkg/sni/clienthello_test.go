@@ -0,0 +1,44 @@
+package sni
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeekClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: "example.com"}).Handshake()
+	}()
+
+	serverName, peeked, err := peekClientHello(server)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", serverName)
+
+	// Peeked bytes must start with a TLS handshake record
+	head := make([]byte, 1)
+	_, err = io.ReadFull(peeked, head)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x16), head[0])
+
+	client.Close()
+}
+
+func TestPeekClientHelloNotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		client.Close()
+	}()
+
+	_, _, err := peekClientHello(server)
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package sni
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"traefik-lazyload/pkg/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ModeProxy = "proxy" // Proxy the raw TLS stream to the container once it is up
+	ModeClose = "close" // Close the connection once the container is starting, so the client retries
+)
+
+const (
+	helloTimeout = 5 * time.Second
+	dialRetry    = 250 * time.Millisecond
+)
+
+// TLS passthrough listener that peeks at the SNI to wake containers routed by
+// traefik TCP routers
+type Server struct {
+	core    *service.Core
+	mode    string
+	timeout time.Duration
+
+	mux      sync.Mutex
+	listener net.Listener
+}
+
+func New(core *service.Core, mode string, timeout time.Duration) *Server {
+	return &Server{
+		core:    core,
+		mode:    mode,
+		timeout: timeout,
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	s.listener = listener
+	s.mux.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	serverName, peeked, err := peekClientHello(conn)
+	if err != nil {
+		logrus.Debugf("Unable to read client hello from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if serverName == "" {
+		logrus.Debugf("Client %s did not send SNI", conn.RemoteAddr())
+		return
+	}
+
+	cts, err := s.core.StartSNI(serverName)
+	if err != nil {
+		logrus.Debugf("Unable to start container for SNI %s: %v", serverName, err)
+		return
+	}
+
+	if s.mode == ModeClose {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	backend, err := s.dialBackend(ctx, cts)
+	cancel()
+	if err != nil {
+		logrus.Warnf("Unable to proxy SNI %s to %s: %v", serverName, cts.Name(), err)
+		return
+	}
+	defer backend.Close()
+
+	logrus.Debugf("Proxying SNI %s from %s to %s", serverName, conn.RemoteAddr(), backend.RemoteAddr())
+	if _, err := io.Copy(backend, peeked); err != nil {
+		return
+	}
+	pipe(conn, backend)
+}
+
+// Wait for the container to be running and accepting connections
+func (s *Server) dialBackend(ctx context.Context, cts *service.ContainerState) (net.Conn, error) {
+	var dialer net.Dialer
+	for {
+		addr, err := s.core.ContainerAddress(ctx, cts)
+		if err == nil {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, "tcp", addr); err == nil {
+				return conn, nil
+			}
+		}
+		if errors.Is(err, service.ErrNoPort) {
+			return nil, err // waiting won't fix missing config
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(dialRetry):
+		}
+	}
+}
+
+// Copy both directions until either side closes
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+}
@@ -0,0 +1,78 @@
+package wake
+
+import (
+	"io"
+	"net/http"
+	"traefik-lazyload/pkg/containers"
+)
+
+// What kind of rule blocked a request
+type Kind int
+
+const (
+	KindNone Kind = iota
+	KindIP
+	KindPath
+	KindUserAgent
+)
+
+// Result of checking a request against the wake rules
+type Decision struct {
+	Kind Kind
+	Rule string // rule that blocked it, eg. "path:/robots.txt"
+}
+
+func (s Decision) Blocked() bool {
+	return s.Kind != KindNone
+}
+
+// How long crawlers are asked to come back after
+const retryAfterBlocked = "3600"
+
+// Answer a blocked request locally, without waking anything
+func (s Decision) WriteResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch s.Kind {
+	case KindPath:
+		if r.URL.Path == "/robots.txt" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, "User-agent: *\nDisallow: /\n")
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case KindIP:
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Forbidden\n")
+	default:
+		// 503 tells crawlers it's temporary, so the site isn't dropped from their index
+		w.Header().Set("Retry-After", retryAfterBlocked)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "Service Unavailable\n")
+	}
+}
+
+// Per-container overrides of the wake rules, from labels. Empty fields inherit the
+// global rules; set ones replace them
+type Override struct {
+	Disabled  bool   // wake.filter=false
+	UserAgent string // wake.useragent, a regex
+	AllowIPs  string // wake.allowips, comma-separated
+	DenyIPs   string // wake.denyips, comma-separated
+	Paths     string // wake.paths, comma-separated
+}
+
+func (s *Override) isEmpty() bool {
+	return *s == Override{}
+}
+
+func OverrideFromContainer(ct *containers.Wrapper) *Override {
+	ret := &Override{}
+	if enabled, ok := ct.ConfigBool("wake.filter", true); ok && !enabled {
+		ret.Disabled = true
+	}
+	ret.UserAgent, _ = ct.Config("wake.useragent")
+	ret.AllowIPs, _ = ct.Config("wake.allowips")
+	ret.DenyIPs, _ = ct.Config("wake.denyips")
+	ret.Paths, _ = ct.Config("wake.paths")
+	return ret
+}
@@ -0,0 +1,243 @@
+package wake
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Requests matching any of these won't wake a container
+type Rules struct {
+	UserAgents []string // regexes
+	AllowIPs   []string // IPs or CIDRs; if any, only these can wake
+	DenyIPs    []string // IPs or CIDRs
+	Paths      []string // exact paths or globs (path.Match), answered locally
+}
+
+type compiledRules struct {
+	userAgents []*regexp.Regexp
+	allowIPs   []*net.IPNet
+	denyIPs    []*net.IPNet
+	paths      []string
+}
+
+// Evaluates wake rules, counting blocked requests per rule
+type Filter struct {
+	global         *compiledRules
+	trustForwarded bool
+
+	mux       sync.Mutex
+	overrides map[Override]*compiledRules // compiled label overrides
+	blocked   map[string]uint64           // rule -> count
+}
+
+func New(rules Rules, trustForwarded bool) (*Filter, error) {
+	global, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{
+		global:         global,
+		trustForwarded: trustForwarded,
+		overrides:      make(map[Override]*compiledRules),
+		blocked:        make(map[string]uint64),
+	}, nil
+}
+
+func compileRules(rules Rules) (ret *compiledRules, err error) {
+	ret = &compiledRules{paths: rules.Paths}
+	for _, expr := range rules.UserAgents {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("user-agent rule %q: %w", expr, err)
+		}
+		ret.userAgents = append(ret.userAgents, re)
+	}
+	if ret.allowIPs, err = parseNets(rules.AllowIPs); err != nil {
+		return nil, err
+	}
+	if ret.denyIPs, err = parseNets(rules.DenyIPs); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Parse IPs and CIDRs; a bare IP is a single-address network
+func parseNets(vals []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, val := range vals {
+		val = strings.TrimSpace(val)
+		if !strings.Contains(val, "/") {
+			ip := net.ParseIP(val)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", val)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(val)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ipnet)
+	}
+	return ret, nil
+}
+
+// Decide whether a request may wake a container, with the container's overrides (if known)
+func (s *Filter) Check(r *http.Request, ov *Override) Decision {
+	if ov != nil && ov.Disabled {
+		return Decision{}
+	}
+	rules := s.rulesFor(ov)
+	decision := s.evaluate(rules, r)
+	if decision.Blocked() {
+		s.mux.Lock()
+		s.blocked[decision.Rule]++
+		s.mux.Unlock()
+	}
+	return decision
+}
+
+func (s *Filter) evaluate(rules *compiledRules, r *http.Request) Decision {
+	// An unknown IP (eg. on a unix socket) is only let through if no IPs are allowed
+	ip := s.clientIP(r)
+	if len(rules.allowIPs) > 0 && (ip == nil || !containsIP(rules.allowIPs, ip)) {
+		return Decision{KindIP, "allowips"}
+	}
+	for _, ipnet := range rules.denyIPs {
+		if ip != nil && ipnet.Contains(ip) {
+			return Decision{KindIP, "denyip:" + ipnet.String()}
+		}
+	}
+
+	for _, pattern := range rules.paths {
+		if matched, _ := path.Match(pattern, r.URL.Path); matched || pattern == r.URL.Path {
+			return Decision{KindPath, "path:" + pattern}
+		}
+	}
+
+	ua := r.UserAgent()
+	for _, re := range rules.userAgents {
+		if re.MatchString(ua) {
+			return Decision{KindUserAgent, "useragent:" + re.String()}
+		}
+	}
+
+	return Decision{}
+}
+
+// Rules with a container's overrides applied. Invalid overrides are logged once, and
+// the global rules used instead
+func (s *Filter) rulesFor(ov *Override) *compiledRules {
+	if ov == nil || ov.isEmpty() {
+		return s.global
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if rules, ok := s.overrides[*ov]; ok {
+		return rules
+	}
+
+	rules, err := ov.apply(s.global)
+	if err != nil {
+		logrus.Warnf("Invalid wake rule labels, using global rules: %v", err)
+		rules = s.global
+	}
+	s.overrides[*ov] = rules
+	return rules
+}
+
+func (s *Override) apply(global *compiledRules) (*compiledRules, error) {
+	rules := *global
+	var err error
+	if s.UserAgent != "" {
+		re, err := regexp.Compile(s.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		rules.userAgents = []*regexp.Regexp{re}
+	}
+	if s.AllowIPs != "" {
+		if rules.allowIPs, err = parseNets(splitCSV(s.AllowIPs)); err != nil {
+			return nil, err
+		}
+	}
+	if s.DenyIPs != "" {
+		if rules.denyIPs, err = parseNets(splitCSV(s.DenyIPs)); err != nil {
+			return nil, err
+		}
+	}
+	if s.Paths != "" {
+		rules.paths = splitCSV(s.Paths)
+	}
+	return &rules, nil
+}
+
+// Address of the client. Behind traefik, that's the last X-Forwarded-For entry
+// (the one traefik added)
+func (s *Filter) clientIP(r *http.Request) net.IP {
+	if s.trustForwarded {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := net.ParseIP(strings.TrimSpace(parts[len(parts)-1])); ip != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of requests blocked by a rule
+type RuleCount struct {
+	Rule  string
+	Count uint64
+}
+
+// Counts of blocked requests, by rule
+func (s *Filter) Blocked() []RuleCount {
+	s.mux.Lock()
+	ret := make([]RuleCount, 0, len(s.blocked))
+	for rule, count := range s.blocked {
+		ret = append(ret, RuleCount{rule, count})
+	}
+	s.mux.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Rule < ret[j].Rule
+	})
+	return ret
+}
+
+func splitCSV(val string) []string {
+	parts := strings.Split(val, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
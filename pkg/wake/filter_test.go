@@ -0,0 +1,74 @@
+package wake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func request(path, ua, remote, forwarded string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("User-Agent", ua)
+	r.RemoteAddr = remote
+	if forwarded != "" {
+		r.Header.Set("X-Forwarded-For", forwarded)
+	}
+	return r
+}
+
+func TestFilterRules(t *testing.T) {
+	filter, err := New(Rules{
+		UserAgents: []string{`(?i)bot\b`},
+		DenyIPs:    []string{"10.0.0.0/8", "192.168.1.5"},
+		Paths:      []string{"/robots.txt", "/.well-known/*"},
+	}, true)
+	assert.NoError(t, err)
+
+	browser := "Mozilla/5.0 (X11; Linux x86_64)"
+	assert.False(t, filter.Check(request("/", browser, "172.17.0.2:1234", ""), nil).Blocked())
+	assert.Equal(t, "useragent:(?i)bot\\b", filter.Check(request("/", "Googlebot/2.1", "172.17.0.2:1234", ""), nil).Rule)
+	assert.Equal(t, "path:/robots.txt", filter.Check(request("/robots.txt", browser, "172.17.0.2:1234", ""), nil).Rule)
+	assert.Equal(t, KindPath, filter.Check(request("/.well-known/security.txt", browser, "172.17.0.2:1234", ""), nil).Kind)
+	assert.Equal(t, "denyip:192.168.1.5/32", filter.Check(request("/", browser, "172.17.0.2:1234", "1.2.3.4, 192.168.1.5"), nil).Rule)
+	assert.Equal(t, "denyip:10.0.0.0/8", filter.Check(request("/", browser, "10.1.2.3:1234", ""), nil).Rule)
+
+	blocked := filter.Blocked()
+	assert.Len(t, blocked, 5)
+	assert.Equal(t, RuleCount{"denyip:10.0.0.0/8", 1}, blocked[0])
+}
+
+func TestFilterOverrides(t *testing.T) {
+	filter, err := New(Rules{
+		UserAgents: []string{"(?i)bot"},
+		Paths:      []string{"/robots.txt"},
+	}, false)
+	assert.NoError(t, err)
+
+	bot := request("/", "Googlebot", "127.0.0.1:1234", "")
+	assert.False(t, filter.Check(bot, &Override{Disabled: true}).Blocked())
+	assert.False(t, filter.Check(bot, &Override{UserAgent: "curl"}).Blocked())
+	assert.True(t, filter.Check(request("/robots.txt", "", "127.0.0.1:1", ""), &Override{UserAgent: "curl"}).Blocked())
+
+	onlyLocal := &Override{AllowIPs: "127.0.0.0/8"}
+	assert.False(t, filter.Check(request("/", "", "127.0.0.1:1", ""), onlyLocal).Blocked())
+	assert.Equal(t, "allowips", filter.Check(request("/", "", "8.8.8.8:1", ""), onlyLocal).Rule)
+	assert.Equal(t, "allowips", filter.Check(request("/", "", "@", ""), onlyLocal).Rule) // unix socket
+	assert.False(t, filter.Check(request("/", "", "@", ""), nil).Blocked())
+
+	// Invalid overrides fall back to the global rules
+	assert.True(t, filter.Check(bot, &Override{UserAgent: "("}).Blocked())
+}
+
+func TestBlockedResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	Decision{KindPath, "path:/robots.txt"}.WriteResponse(w, request("/robots.txt", "", "", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Disallow: /")
+
+	w = httptest.NewRecorder()
+	Decision{KindUserAgent, "useragent:bot"}.WriteResponse(w, request("/", "", "", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, retryAfterBlocked, w.Header().Get("Retry-After"))
+}
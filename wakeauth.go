@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"traefik-lazyload/pkg/auth"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Per-container wake policies (`lazyloader.wake.auth`)
+const (
+	wakeAuthNone  = ""
+	wakeAuthClick = "click" // confirm with a button on the splash page
+	wakeAuthToken = "token" // secret in a query param or header
+	wakeAuthBasic = "basic" // http basic auth
+)
+
+const (
+	wakeTokenParam  = "lltoken"
+	wakeTokenHeader = "X-Wake-Token"
+	wakeCSRFField   = "csrf"
+)
+
+type WakeModel struct {
+	Hostname  string
+	Name      string
+	CSRFToken string
+}
+
+// Check that a request is allowed to start a container. If not, a response has been
+// written. Returns whether the client should be redirected (after a confirming POST)
+func (s *controller) authorizeWake(w http.ResponseWriter, r *http.Request, host string, ct *containers.Wrapper) (ok, redirect bool) {
+	if ct == nil {
+		// Nothing serves the host
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "not found")
+		return false, false
+	}
+	policy, _ := ct.Config("wake.auth")
+	if policy == wakeAuthNone || s.core.IsStarted(ct.Name()) {
+		return true, false
+	}
+
+	switch policy {
+	case wakeAuthClick:
+		if r.Method == http.MethodPost {
+			if s.csrf.Verify(r, host, r.PostFormValue(wakeCSRFField)) {
+				return true, true
+			}
+			logrus.Warnf("Invalid wake confirmation for %s from %s", host, r.RemoteAddr)
+			http.Error(w, "Invalid or expired confirmation, please reload", http.StatusForbidden)
+			return false, false
+		}
+		s.writeWakeConfirm(w, r, host, ct)
+		return false, false
+
+	case wakeAuthToken:
+		secret, _ := ct.Config("wake.token")
+		token := r.URL.Query().Get(wakeTokenParam)
+		if token == "" {
+			token = r.Header.Get(wakeTokenHeader)
+		}
+		if secret != "" && auth.SecretEqual(token, secret) {
+			return true, false
+		}
+		http.Error(w, "A wake token is required to start "+host, http.StatusUnauthorized)
+		return false, false
+
+	case wakeAuthBasic:
+		list, _ := ct.Config("wake.users")
+		users, err := auth.ParseHtpasswdList(list)
+		if err != nil {
+			logrus.Warnf("Invalid wake.users on %s: %v", ct.NameID(), err)
+		}
+		if user, pass, hasAuth := r.BasicAuth(); hasAuth && err == nil && users.Verify(user, pass) {
+			return true, false
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+host+`", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false, false
+	}
+
+	logrus.Warnf("Unknown wake.auth policy %q on %s, refusing to start", policy, ct.NameID())
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false, false
+}
+
+// Ask the user to confirm starting the container
+func (s *controller) writeWakeConfirm(w http.ResponseWriter, r *http.Request, host string, ct *containers.Wrapper) {
+	w.Header().Set("Cache-Control", "no-store")
+	if negotiateResponse(r.Header.Get("Accept")) != responseHTML {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, host+" is stopped, and must be started from a browser\n")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := s.assets.Wake().Execute(w, WakeModel{
+		Hostname:  host,
+		Name:      ct.Name(),
+		CSRFToken: s.csrf.Issue(w, r, host),
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}